@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 列表中按钮的可用状态由 action-rules.json 计算:
+// 用户有 roles 中的任一角色或拥有 code 权限码, 且记录满足 when 中的全部条件时为 true
+
+type actionRuleT struct {
+	Field string              `json:"field"`
+	Name  string              `json:"name"`
+	Code  string              `json:"code"`
+	Roles []string            `json:"roles"`
+	When  map[string][]string `json:"when"`
+}
+
+func readActionRules() map[string][]actionRuleT {
+	plan, err := ioutil.ReadFile("action-rules.json")
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		panic(err)
+	}
+
+	rules := make(map[string][]actionRuleT)
+	err = json.Unmarshal(plan, &rules)
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+func actionGranted(rule actionRuleT, roles, codes sliceString) bool {
+	for _, role := range rule.Roles {
+		if roles.search(role) {
+			return true
+		}
+	}
+	return rule.Code != "" && codes.search(rule.Code)
+}
+
+// actionFlags 计算一行记录上各个按钮的状态, 没有配置规则的字段不返回
+func actionFlags(rules []actionRuleT, roles, codes sliceString, row map[string]interface{}) map[string]bool {
+	flags := make(map[string]bool)
+	for _, rule := range rules {
+		allowed := actionGranted(rule, roles, codes)
+		for field, values := range rule.When {
+			if !allowed {
+				break
+			}
+			str := ""
+			if row[field] != nil {
+				str = fmt.Sprint(row[field])
+			}
+			allowed = sliceString(values).search(str)
+		}
+		flags[rule.Field] = allowed
+	}
+	return flags
+}
+
+// requestPermissions 调用者的角色和权限码, token 无效时都为空
+func requestPermissions(r *http.Request) (sliceString, sliceString) {
+	token := r.Header.Get("token")
+	if token == "" {
+		token = r.FormValue("token")
+	}
+	claims, err := parseToken(token)
+	if err != nil {
+		return sliceString{}, sliceString{}
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	roles := requestRoles(db, claims.UserID)
+	return roles, roleCodes(db, roles)
+}
+
+// applyActionRules 按列表接口的规则改写每一行的按钮状态
+func applyActionRules(r *http.Request, list string, rows []map[string]interface{}) {
+	rules := readActionRules()[list]
+	if len(rules) == 0 {
+		return
+	}
+
+	roles, codes := requestPermissions(r)
+	for _, row := range rows {
+		for field, flag := range actionFlags(rules, roles, codes, row) {
+			row[field] = flag
+		}
+	}
+}
+
+func actionCodes(codes map[string]bool) {
+	for _, rules := range readActionRules() {
+		for _, rule := range rules {
+			if rule.Code != "" {
+				codes[rule.Code] = true
+			}
+		}
+	}
+}
+
+// PermissionActions 当前用户可以使用的按钮权限码
+func PermissionActions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if _, ok := tokenUserID(w, r); !ok {
+		return
+	}
+
+	type actionT struct {
+		List  string `json:"list"`
+		Field string `json:"field"`
+		Name  string `json:"name"`
+		Code  string `json:"code"`
+	}
+
+	roles, codes := requestPermissions(r)
+
+	granted := make(map[string]bool)
+	actions := []actionT{}
+	for list, rules := range readActionRules() {
+		for _, rule := range rules {
+			if !actionGranted(rule, roles, codes) {
+				continue
+			}
+			if rule.Code != "" {
+				granted[rule.Code] = true
+			}
+			actions = append(actions, actionT{
+				List:  list,
+				Field: rule.Field,
+				Name:  rule.Name,
+				Code:  rule.Code,
+			})
+		}
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		if actions[i].List != actions[j].List {
+			return actions[i].List < actions[j].List
+		}
+		return actions[i].Field < actions[j].Field
+	})
+
+	result := []string{}
+	for code := range granted {
+		result = append(result, code)
+	}
+	sort.Strings(result)
+
+	json.NewEncoder(w).Encode(resResultT{
+		Code: "0",
+		Des:  "",
+		Result: map[string]interface{}{
+			"codes":   result,
+			"actions": actions,
+		},
+	})
+}
+
+// seedActionPermission admin 拥有全部按钮权限码
+func seedActionPermission(tx *sqlx.Tx) {
+	codes := make(map[string]bool)
+	actionCodes(codes)
+	for code := range codes {
+		tx.MustExec("insert or ignore into role_permission(role, code) values($1, $2)", "admin", code)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/xid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// token 默认有效期, 登录时可以用 expiresIn (秒) 缩短, 方便测试过期
+const tokenTTL = 2 * time.Hour
+
+// 过期后还可以刷新的时间
+const refreshWindow = 24 * time.Hour
+
+type credentialT struct {
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	ExpiresIn int64  `json:"expiresIn"`
+	CaptchaID string `json:"captchaId"`
+	Captcha   string `json:"captcha"`
+}
+
+// requestCredential 同时支持 query, 表单和 json body
+func requestCredential(r *http.Request) credentialT {
+	cred := credentialT{}
+	if strings.Contains(r.Header.Get("Content-Type"), "json") {
+		json.NewDecoder(r.Body).Decode(&cred)
+	} else {
+		r.ParseForm()
+	}
+
+	if cred.Username == "" {
+		cred.Username = r.FormValue("username")
+	}
+	if cred.Password == "" {
+		cred.Password = r.FormValue("password")
+	}
+	if cred.CaptchaID == "" {
+		cred.CaptchaID = r.FormValue("captchaId")
+	}
+	if cred.Captcha == "" {
+		cred.Captcha = r.FormValue("captcha")
+	}
+	if cred.ExpiresIn == 0 {
+		cred.ExpiresIn, _ = strconv.ParseInt(r.FormValue("expiresIn"), 10, 64)
+	}
+	return cred
+}
+
+// checkPassword 校验用户名密码, 返回 userId
+func checkPassword(db *sqlx.DB, username, password string) (string, error) {
+	user := struct {
+		ID   string `db:"id"`
+		Hash string `db:"password_hash"`
+	}{}
+	err := db.Get(&user, "select id, password_hash from mock_user where username=$1", username)
+	if err == sql.ErrNoRows {
+		return "", errors.New("用户名或密码错误")
+	} else if err != nil {
+		panic(err)
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(password)) != nil {
+		return "", errors.New("用户名或密码错误")
+	}
+	return user.ID, nil
+}
+
+// issueToken 为用户签发新 token, 带上当前的角色和市场
+func issueToken(db *sqlx.DB, userID, tenant string, expiresIn int64) string {
+	ttl := tokenTTL
+	if expiresIn > 0 && time.Duration(expiresIn)*time.Second < ttl {
+		ttl = time.Duration(expiresIn) * time.Second
+	}
+
+	now := time.Now()
+	return signToken(tokenClaimsT{
+		UserID: userID,
+		Roles:  requestRoles(db, userID),
+		Iat:    now.Unix(),
+		Exp:    now.Add(ttl).Unix(),
+		Jti:    xid.New().String(),
+		Tenant: tenant,
+	})
+}
+
+func tokenRevoked(jti string) bool {
+	db, err := sqlx.Connect("sqlite3", "_db.db")
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	var count int
+	err = db.Get(&count, "select count(*) from revoked_token where jti=$1", jti)
+	if err != nil {
+		panic(err)
+	}
+	return count > 0
+}
+
+// revokeToken 注销记录不区分市场, 保存在 _db.db 中
+func revokeToken(claims tokenClaimsT) {
+	db, err := sqlx.Connect("sqlite3", "_db.db")
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	db.MustExec("insert or ignore into revoked_token(jti, user_id, expires_at, revoked_at) values($1, $2, $3, $4)",
+		claims.Jti, claims.UserID, claims.Exp, time.Now().Format("2006-01-02 15:04:05"))
+	// 已过期的记录不再需要
+	db.MustExec("delete from revoked_token where expires_at < $1", time.Now().Add(-refreshWindow).Unix())
+}
+
+func hashPassword(password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return string(hash)
+}
+
+// refresh 用未注销的 token 换一个新的, 过期不超过 refreshWindow 时也可以刷新
+func refresh(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	claims, err := verifyToken(r.Header.Get("token"))
+	if err != nil {
+		writeUnauthorized(w, err.Error())
+		return
+	}
+	if time.Now().After(time.Unix(claims.Exp, 0).Add(refreshWindow)) {
+		writeUnauthorized(w, "token 已超过可刷新时间")
+		return
+	}
+
+	revokeToken(claims)
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	token := issueToken(db, claims.UserID, claims.Tenant, 0)
+
+	db.Close()
+
+	json.NewEncoder(w).Encode(codeRetT{
+		Code:   "0",
+		Result: token,
+		Des:    "",
+	})
+}
+
+// logout 注销当前 token
+func logout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	claims, err := verifyToken(r.Header.Get("token"))
+	if err != nil {
+		writeUnauthorized(w, err.Error())
+		return
+	}
+
+	revokeToken(claims)
+
+	json.NewEncoder(w).Encode(codeRetT{
+		Code: "0",
+		Des:  "已退出",
+	})
+}
+
+// seedMockUser 默认用户 username / password, userId 和旧数据中的一致
+func seedMockUser(tx *sqlx.Tx) {
+	tx.MustExec("insert or ignore into mock_user(id, username, password_hash, created_at) values($1, $2, $3, $4)",
+		"0013", "username", hashPassword("password"), time.Now().Format("2006-01-02 15:04:05"))
+}
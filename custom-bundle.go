@@ -0,0 +1,526 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/xid"
+)
+
+type bundleColumnT struct {
+	Name     string `db:"name" json:"name"`
+	Value    string `db:"value" json:"value"`
+	Fixed    string `db:"fixed" json:"fixed"`
+	Location string `db:"location" json:"location"`
+	Rule     string `db:"rule" json:"rule"`
+	Seq      int    `db:"seq" json:"seq"`
+	Parent   string `db:"parent" json:"parent"`
+	MinWidth int    `db:"min_width" json:"minWidth"`
+	MaxWidth int    `db:"max_width" json:"maxWidth"`
+}
+
+type bundleFilterT struct {
+	Name          string `db:"name" json:"name"`
+	Value         string `db:"value" json:"value"`
+	Fixed         string `db:"fixed" json:"fixed"`
+	Seq           int    `db:"seq" json:"seq"`
+	Type          string `db:"type" json:"type"`
+	Operators     string `db:"operators" json:"operators"`
+	DefaultValue  string `db:"default_value" json:"defaultValue"`
+	OptionsSource string `db:"options_source" json:"optionsSource"`
+}
+
+type bundleModuleT struct {
+	ModuleID string          `json:"moduleId"`
+	Columns  []bundleColumnT `json:"columns"`
+	Filters  []bundleFilterT `json:"filters"`
+}
+
+type bundleT struct {
+	Version int             `json:"version"`
+	Modules []bundleModuleT `json:"modules"`
+}
+
+var bundleCSVHeader = []string{"kind", "module_id", "name", "value", "fixed", "location", "rule", "seq", "parent", "type", "operators", "default_value", "options_source", "min_width", "max_width"}
+
+func exportBundle(db *sqlx.DB, id string) bundleT {
+	moduleIDs := []string{}
+	if id != "" {
+		moduleIDs = append(moduleIDs, id)
+	} else {
+		err := db.Select(&moduleIDs, "select module_id from raw_column union select module_id from raw_filter order by module_id")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	bundle := bundleT{
+		Version: 1,
+		Modules: []bundleModuleT{},
+	}
+	for _, moduleID := range moduleIDs {
+		module := bundleModuleT{
+			ModuleID: moduleID,
+			Columns:  []bundleColumnT{},
+			Filters:  []bundleFilterT{},
+		}
+		err := db.Select(&module.Columns, "select name, value, fixed, location, rule, seq, coalesce(parent, '') as parent, coalesce(min_width, 0) as min_width, coalesce(max_width, 0) as max_width from raw_column where module_id=$1 order by seq", moduleID)
+		if err != nil {
+			panic(err)
+		}
+		err = db.Select(&module.Filters, `
+						select name, value, fixed, seq, coalesce(type, '') as type,
+						coalesce(operators, '') as operators,
+						coalesce(default_value, '') as default_value,
+						coalesce(options_source, '') as options_source
+						from raw_filter where module_id=$1 order by seq
+						`, moduleID)
+		if err != nil {
+			panic(err)
+		}
+		bundle.Modules = append(bundle.Modules, module)
+	}
+	return bundle
+}
+
+// ExportMaintenance 导出模块定义, 不传 id 时导出全部模块
+func ExportMaintenance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	formats := r.URL.Query()["format"]
+	format := "json"
+	if len(formats) >= 1 {
+		format = formats[0]
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	bundle := exportBundle(db, id)
+
+	db.Close()
+
+	if format != "csv" {
+		w.Header().Set("Content-Disposition", "attachment; filename=modules.json")
+		json.NewEncoder(w).Encode(bundle)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=modules.csv")
+
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Write(bundleCSVHeader)
+	for _, module := range bundle.Modules {
+		for _, c := range module.Columns {
+			csvWriter.Write([]string{"column", module.ModuleID, c.Name, c.Value, c.Fixed, c.Location, c.Rule, strconv.Itoa(c.Seq), c.Parent, "", "", "", "", strconv.Itoa(c.MinWidth), strconv.Itoa(c.MaxWidth)})
+		}
+		for _, f := range module.Filters {
+			csvWriter.Write([]string{"filter", module.ModuleID, f.Name, f.Value, f.Fixed, "", "", strconv.Itoa(f.Seq), "", f.Type, f.Operators, f.DefaultValue, f.OptionsSource, "", ""})
+		}
+	}
+	csvWriter.Flush()
+}
+
+// readBundleCSV 把导出的 csv 还原成 bundle
+func readBundleCSV(reader io.Reader) (bundleT, error) {
+	bundle := bundleT{Version: 1}
+
+	csvReader := csv.NewReader(reader)
+	header, err := csvReader.Read()
+	if err != nil {
+		return bundle, err
+	}
+
+	index := make(map[string]int)
+	for i, name := range header {
+		index[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"kind", "module_id", "value"} {
+		if _, ok := index[name]; !ok {
+			return bundle, fmt.Errorf("缺少列 %s", name)
+		}
+	}
+
+	modules := make(map[string]int)
+	line := 1
+	for {
+		row, err := csvReader.Read()
+		line++
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return bundle, err
+		}
+
+		field := func(name string) string {
+			if i, ok := index[name]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+
+		seq := 0
+		if s := field("seq"); s != "" {
+			seq, err = strconv.Atoi(s)
+			if err != nil {
+				return bundle, fmt.Errorf("第 %d 行 seq %q 不是整数", line, s)
+			}
+		}
+
+		widths := make(map[string]int)
+		for _, name := range []string{"min_width", "max_width"} {
+			if s := field(name); s != "" {
+				widths[name], err = strconv.Atoi(s)
+				if err != nil {
+					return bundle, fmt.Errorf("第 %d 行 %s %q 不是整数", line, name, s)
+				}
+			}
+		}
+
+		moduleID := field("module_id")
+		i, ok := modules[moduleID]
+		if !ok {
+			i = len(bundle.Modules)
+			modules[moduleID] = i
+			bundle.Modules = append(bundle.Modules, bundleModuleT{ModuleID: moduleID})
+		}
+
+		switch field("kind") {
+		case "column":
+			bundle.Modules[i].Columns = append(bundle.Modules[i].Columns, bundleColumnT{
+				Name:     field("name"),
+				Value:    field("value"),
+				Fixed:    field("fixed"),
+				Location: field("location"),
+				Rule:     field("rule"),
+				Seq:      seq,
+				Parent:   field("parent"),
+				MinWidth: widths["min_width"],
+				MaxWidth: widths["max_width"],
+			})
+		case "filter":
+			bundle.Modules[i].Filters = append(bundle.Modules[i].Filters, bundleFilterT{
+				Name:          field("name"),
+				Value:         field("value"),
+				Fixed:         field("fixed"),
+				Seq:           seq,
+				Type:          field("type"),
+				Operators:     field("operators"),
+				DefaultValue:  field("default_value"),
+				OptionsSource: field("options_source"),
+			})
+		default:
+			return bundle, fmt.Errorf("第 %d 行 kind %q 应为 column 或 filter", line, field("kind"))
+		}
+	}
+	return bundle, nil
+}
+
+// validateBundle 校验 bundle, 与库中相同的旧规则不再校验, 以便导出的数据可以原样导回
+func validateBundle(tenant string, bundle bundleT, storedRules map[string]string) []string {
+	errors := []string{}
+	fixedValues := sliceString{"", "0", "1"}
+
+	for _, module := range bundle.Modules {
+		if module.ModuleID == "" {
+			errors = append(errors, "moduleId 不能为空")
+			continue
+		}
+
+		seen := make(map[string]bool)
+		for _, c := range module.Columns {
+			seen[c.Value] = false
+		}
+		for _, c := range module.Columns {
+			if _, ok := seen[c.Parent]; c.Parent != "" && !ok {
+				if _, stored := storedRules[module.ModuleID+"|"+c.Parent]; !stored {
+					errors = append(errors, fmt.Sprintf("%s: 列 %s 的分组 %s 不存在", module.ModuleID, c.Value, c.Parent))
+				}
+			}
+		}
+		for _, c := range module.Columns {
+			if c.Value == "" {
+				errors = append(errors, fmt.Sprintf("%s: 列 value 不能为空", module.ModuleID))
+			} else if seen[c.Value] {
+				errors = append(errors, fmt.Sprintf("%s: 列 %s 重复", module.ModuleID, c.Value))
+			}
+			seen[c.Value] = true
+			if !fixedValues.search(c.Fixed) {
+				errors = append(errors, fmt.Sprintf("%s: 列 %s fixed 应为 0 或 1", module.ModuleID, c.Value))
+			}
+			if err := checkWidthRange(c.MinWidth, c.MaxWidth); err != nil {
+				errors = append(errors, fmt.Sprintf("%s: 列 %s %s", module.ModuleID, c.Value, err.Error()))
+			}
+			if rule, ok := storedRules[module.ModuleID+"|"+c.Value]; ok && rule == c.Rule {
+				continue
+			}
+			if _, err := parseRule(c.Rule); err != nil {
+				errors = append(errors, fmt.Sprintf("%s: 列 %s %s", module.ModuleID, c.Value, err.Error()))
+			}
+		}
+
+		seen = make(map[string]bool)
+		for _, f := range module.Filters {
+			if f.Value == "" {
+				errors = append(errors, fmt.Sprintf("%s: 过滤 value 不能为空", module.ModuleID))
+			} else if seen[f.Value] {
+				errors = append(errors, fmt.Sprintf("%s: 过滤 %s 重复", module.ModuleID, f.Value))
+			}
+			seen[f.Value] = true
+			if !fixedValues.search(f.Fixed) {
+				errors = append(errors, fmt.Sprintf("%s: 过滤 %s fixed 应为 0 或 1", module.ModuleID, f.Value))
+			}
+			if err := checkFilterDefinition(tenant, f.Type, f.Operators, f.DefaultValue, f.OptionsSource); err != nil {
+				errors = append(errors, fmt.Sprintf("%s: 过滤 %s %s", module.ModuleID, f.Value, err.Error()))
+			}
+		}
+	}
+	return errors
+}
+
+// ImportMaintenance 导入模块定义, 按 module_id 和 value 新增或更新.
+// 参数 revisions 为可选的期望版本号, 如 {"moduleId": {"table": 3, "filter": 1}}, 只检查传了的模块和 bundle 中有数据的 target
+func ImportMaintenance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	var bundle bundleT
+	var err error
+	if strings.Contains(r.Header.Get("Content-Type"), "csv") {
+		bundle, err = readBundleCSV(r.Body)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&bundle)
+	}
+	if err != nil {
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "1",
+			Des:  err.Error(),
+		})
+		return
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	type storedRuleT struct {
+		Key  string `db:"key"`
+		Rule string `db:"rule"`
+	}
+	stored := []storedRuleT{}
+	err = db.Select(&stored, "select module_id || '|' || value as key, rule from raw_column")
+	if err != nil {
+		panic(err)
+	}
+	storedRules := make(map[string]string)
+	for _, s := range stored {
+		storedRules[s.Key] = s.Rule
+	}
+
+	if errors := validateBundle(requestTenant(r).ID, bundle, storedRules); len(errors) > 0 {
+		db.Close()
+		json.NewEncoder(w).Encode(resResultT{
+			Code:   "1",
+			Des:    "导入校验失败",
+			Result: errors,
+		})
+		return
+	}
+
+	// 导出时的版本号属于导出的环境, 不能和当前市场比较, 只检查调用方传入的版本号
+	expected := make(map[string]map[string]int)
+	if str := r.URL.Query().Get("revisions"); str != "" {
+		if err := json.Unmarshal([]byte(str), &expected); err != nil {
+			db.Close()
+			json.NewEncoder(w).Encode(resResultT{
+				Code: "1",
+				Des:  "revisions 参数格式错误: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	conflicts := []revisionConflictT{}
+	for _, module := range bundle.Modules {
+		targets := []string{}
+		if _, ok := expected[module.ModuleID]["table"]; ok && len(module.Columns) > 0 {
+			targets = append(targets, "table")
+		}
+		if _, ok := expected[module.ModuleID]["filter"]; ok && len(module.Filters) > 0 {
+			targets = append(targets, "filter")
+		}
+		_, c := checkRevisions(db, module.ModuleID, expected[module.ModuleID], targets...)
+		conflicts = append(conflicts, c...)
+	}
+	if len(conflicts) > 0 {
+		db.Close()
+		writeRevisionConflicts(w, conflicts)
+		return
+	}
+
+	type importResultT struct {
+		Created   int `json:"created"`
+		Updated   int `json:"updated"`
+		Unchanged int `json:"unchanged"`
+	}
+
+	result := importResultT{}
+	actor := requestActor(r)
+	tx := db.MustBegin()
+
+	// upsert 按 value 对比现有行, 值相同的计为 unchanged. 版本号在检查之后被修改时返回 false
+	upsert := func(moduleID, target string, rows []map[string]interface{}) bool {
+		table := historyTables[target]
+		existing := make(map[string]map[string]interface{})
+		for _, row := range snapshotRows(tx, table, "module_id=$1", moduleID) {
+			existing[fmt.Sprint(row["value"])] = row
+		}
+
+		revision := 0
+		for _, row := range rows {
+			value := row["value"].(string)
+			before, ok := existing[value]
+			if ok {
+				same := true
+				for field, v := range row {
+					if fmt.Sprint(before[field]) != fmt.Sprint(v) {
+						same = false
+					}
+				}
+				if same {
+					result.Unchanged++
+					continue
+				}
+			}
+
+			if revision == 0 {
+				current, ok := expected[moduleID][target]
+				if !ok {
+					err := tx.Get(&current, "select coalesce(max(revision), 0) from module_revision where module_id=$1 and target=$2", moduleID, target)
+					if err != nil {
+						panic(err)
+					}
+				}
+				if !bumpModuleRevision(tx, moduleID, target, current) {
+					return false
+				}
+				revision = current + 1
+			}
+
+			fields := []string{}
+			args := []interface{}{}
+			for field, v := range row {
+				fields = append(fields, field)
+				args = append(args, v)
+			}
+
+			if ok {
+				rowID := before["id"].(string)
+				sqlStr := "update " + table + " set "
+				for i, field := range fields {
+					sqlStr += fmt.Sprintf("%s=$%d, ", field, i+1)
+				}
+				sqlStr = strings.TrimSuffix(sqlStr, ", ") + fmt.Sprintf(" where id=$%d", len(args)+1)
+				tx.MustExec(sqlStr, append(args, rowID)...)
+				recordHistory(tx, moduleID, target, revision, actor, "update", rowID, before, snapshotRow(tx, table, rowID))
+				result.Updated++
+			} else {
+				rowID := xid.New().String()
+				placeholders := []string{}
+				for i := range fields {
+					placeholders = append(placeholders, fmt.Sprintf("$%d", i+3))
+				}
+				tx.MustExec("insert into "+table+"(id, module_id, "+strings.Join(fields, ", ")+") values($1, $2, "+strings.Join(placeholders, ", ")+")", append([]interface{}{rowID, moduleID}, args...)...)
+				recordHistory(tx, moduleID, target, revision, actor, "insert", rowID, nil, snapshotRow(tx, table, rowID))
+				result.Created++
+			}
+		}
+		return true
+	}
+
+	conflict := func(moduleID, target string) {
+		tx.Rollback()
+		_, conflicts := checkRevisions(db, moduleID, expected[moduleID], target)
+		db.Close()
+		writeRevisionConflicts(w, conflicts)
+	}
+
+	for _, module := range bundle.Modules {
+		columns := []map[string]interface{}{}
+		for _, c := range module.Columns {
+			columns = append(columns, map[string]interface{}{
+				"name":      c.Name,
+				"value":     c.Value,
+				"fixed":     c.Fixed,
+				"location":  c.Location,
+				"rule":      c.Rule,
+				"seq":       c.Seq,
+				"parent":    c.Parent,
+				"min_width": c.MinWidth,
+				"max_width": c.MaxWidth,
+			})
+		}
+		if !upsert(module.ModuleID, "table", columns) {
+			conflict(module.ModuleID, "table")
+			return
+		}
+		if err := checkColumnGroups(tx, module.ModuleID); err != nil {
+			tx.Rollback()
+			db.Close()
+			json.NewEncoder(w).Encode(resResultT{
+				Code: "1",
+				Des:  err.Error(),
+			})
+			return
+		}
+
+		filters := []map[string]interface{}{}
+		for _, f := range module.Filters {
+			filters = append(filters, map[string]interface{}{
+				"name":           f.Name,
+				"value":          f.Value,
+				"fixed":          f.Fixed,
+				"seq":            f.Seq,
+				"type":           filterType(f.Type),
+				"operators":      f.Operators,
+				"default_value":  f.DefaultValue,
+				"options_source": f.OptionsSource,
+			})
+		}
+		if !upsert(module.ModuleID, "filter", filters) {
+			conflict(module.ModuleID, "filter")
+			return
+		}
+	}
+
+	tx.Commit()
+	db.Close()
+
+	res := resResultT{
+		Code:   "0",
+		Des:    "",
+		Result: result,
+	}
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CSV 导入: 表不存在时按数据推断列类型建表, 存在时按 key 列更新或插入.
+// 行号按记录计, 表头为第 1 行
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// 登录和迁移相关的表不允许导入
+var protectedTables = sliceString{"mock_user", "mock_role", "revoked_token", "login_attempt", "captcha", "schema_migration"}
+
+type csvColumnT struct {
+	Name string `db:"name" json:"name"`
+	Type string `db:"type" json:"type"`
+}
+
+type csvFailureT struct {
+	Line  int    `json:"line"`
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+type csvReportT struct {
+	Table    string        `json:"table"`
+	Created  bool          `json:"created"`
+	Columns  []csvColumnT  `json:"columns"`
+	Inserted []int         `json:"inserted"`
+	Updated  []int         `json:"updated"`
+	Failed   []csvFailureT `json:"failed"`
+	Rollback bool          `json:"rollback"`
+}
+
+// inferColumnType 全部是整数时为 INTEGER, 全部是数字时为 REAL, 否则为 TEXT; 空值不参与推断
+func inferColumnType(records [][]string, index int) string {
+	t := "INTEGER"
+	seen := false
+	for _, record := range records {
+		if index >= len(record) || record[index] == "" {
+			continue
+		}
+		seen = true
+		if t == "INTEGER" {
+			if _, err := strconv.ParseInt(record[index], 10, 64); err == nil {
+				continue
+			}
+			t = "REAL"
+		}
+		if _, err := strconv.ParseFloat(record[index], 64); err != nil {
+			return "TEXT"
+		}
+	}
+	if !seen {
+		return "TEXT"
+	}
+	return t
+}
+
+// csvValue 按列类型转换, 空字符串写入 NULL
+func csvValue(t, value string) (interface{}, error) {
+	if value == "" {
+		return nil, nil
+	}
+	switch strings.ToUpper(t) {
+	case "INTEGER", "INT":
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s 不是整数", value)
+		}
+		return v, nil
+	case "REAL", "FLOAT", "DOUBLE", "NUMERIC":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s 不是数字", value)
+		}
+		return v, nil
+	}
+	return value, nil
+}
+
+func tableColumns(db *sqlx.DB, table string) []csvColumnT {
+	columns := []csvColumnT{}
+	err := db.Select(&columns, "select name, type from pragma_table_info($1) order by cid", table)
+	if err != nil {
+		panic(err)
+	}
+	return columns
+}
+
+// requestCSV 上传的文件 (字段 file), 或者直接以 body 提交的 CSV
+func requestCSV(r *http.Request) (io.ReadCloser, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.ParseMultipartForm(32 << 20)
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("缺少上传文件 file")
+		}
+		return file, nil
+	}
+	return r.Body, nil
+}
+
+// ImportCSV 导入 CSV, 参数 table, key, strict=1 时有失败行则全部回滚
+func ImportCSV(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	table := r.URL.Query().Get("table")
+	key := r.URL.Query().Get("key")
+	strict := r.URL.Query().Get("strict") == "1"
+
+	fail := func(des string, result interface{}) {
+		json.NewEncoder(w).Encode(resResultT{
+			Code:   "1",
+			Des:    des,
+			Result: result,
+		})
+	}
+
+	if !identifierPattern.MatchString(table) || protectedTables.search(table) || strings.HasPrefix(table, "sqlite_") {
+		fail(fmt.Sprintf("表名 %q 不合法", table), nil)
+		return
+	}
+	if key != "" && !identifierPattern.MatchString(key) {
+		fail(fmt.Sprintf("key %q 不合法", key), nil)
+		return
+	}
+
+	body, err := requestCSV(r)
+	if err != nil {
+		fail(err.Error(), nil)
+		return
+	}
+	defer body.Close()
+
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		fail("读取表头失败: "+err.Error(), nil)
+		return
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+
+	// 格式错误的行记为失败, 不中断导入; 行号是记录在文件中的起始行, 字段中有换行时与记录序号不同
+	records := [][]string{}
+	lines := []int{}
+	failed := []csvFailureT{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			line := 0
+			if e, ok := err.(*csv.ParseError); ok {
+				line = e.StartLine
+			}
+			failed = append(failed, csvFailureT{Line: line, Error: err.Error()})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		records = append(records, record)
+		lines = append(lines, line)
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	report := csvReportT{
+		Table:    table,
+		Inserted: []int{},
+		Updated:  []int{},
+	}
+
+	errors := []string{}
+	seen := make(map[string]bool)
+	for _, name := range header {
+		if !identifierPattern.MatchString(name) {
+			errors = append(errors, fmt.Sprintf("列名 %q 不合法", name))
+		} else if seen[strings.ToLower(name)] {
+			errors = append(errors, fmt.Sprintf("列 %s 重复", name))
+		}
+		seen[strings.ToLower(name)] = true
+	}
+	if key != "" && !seen[strings.ToLower(key)] {
+		errors = append(errors, fmt.Sprintf("CSV 中没有 key 列 %s", key))
+	}
+
+	columns := tableColumns(db, table)
+	report.Created = len(columns) == 0
+	if report.Created {
+		for i, name := range header {
+			columns = append(columns, csvColumnT{Name: name, Type: inferColumnType(records, i)})
+		}
+	} else {
+		if key == "" {
+			errors = append(errors, fmt.Sprintf("表 %s 已存在, 需要指定 key", table))
+		}
+		// key 在表中不唯一时一行会更新多条记录
+		if key != "" {
+			duplicates := []string{}
+			err = db.Select(&duplicates, "select cast("+key+" as text) from "+table+" where "+key+" is not null group by "+key+" having count(*) > 1 limit 5")
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("表 %s 中没有 key 列 %s", table, key))
+			} else if len(duplicates) > 0 {
+				errors = append(errors, fmt.Sprintf("表 %s 的 key 列 %s 有重复值 %s, 不能按 key 更新", table, key, strings.Join(duplicates, ", ")))
+			}
+		}
+		types := make(map[string]bool)
+		for _, c := range columns {
+			types[strings.ToLower(c.Name)] = true
+		}
+		for _, name := range header {
+			if !types[strings.ToLower(name)] {
+				errors = append(errors, fmt.Sprintf("表 %s 中没有列 %s", table, name))
+			}
+		}
+	}
+	if len(errors) > 0 {
+		fail("CSV 校验失败", errors)
+		return
+	}
+	report.Columns = columns
+
+	types := make(map[string]string)
+	for _, c := range columns {
+		types[strings.ToLower(c.Name)] = c.Type
+	}
+	keyIndex := -1
+	for i, name := range header {
+		if strings.EqualFold(name, key) {
+			keyIndex = i
+		}
+	}
+
+	tx := db.MustBegin()
+	if report.Created {
+		definitions := []string{}
+		for _, c := range columns {
+			definition := c.Name + " " + c.Type
+			if strings.EqualFold(c.Name, key) {
+				definition += " UNIQUE"
+			}
+			definitions = append(definitions, definition)
+		}
+		tx.MustExec("create table " + table + " (" + strings.Join(definitions, ", ") + ")")
+	}
+
+	placeholders := []string{}
+	assignments := []string{}
+	for i, name := range header {
+		placeholders = append(placeholders, "$"+strconv.Itoa(i+1))
+		assignments = append(assignments, name+"=$"+strconv.Itoa(i+1))
+	}
+	insert := "insert into " + table + "(" + strings.Join(header, ", ") + ") values(" + strings.Join(placeholders, ", ") + ")"
+	update := "update " + table + " set " + strings.Join(assignments, ", ") + " where " + key + "=$" + strconv.Itoa(len(header)+1)
+
+	for i, record := range records {
+		line := lines[i]
+
+		rowKey := ""
+		if keyIndex >= 0 && keyIndex < len(record) {
+			rowKey = record[keyIndex]
+		}
+		rowFail := func(format string, args ...interface{}) {
+			failed = append(failed, csvFailureT{Line: line, Key: rowKey, Error: fmt.Sprintf(format, args...)})
+		}
+
+		if len(record) != len(header) {
+			rowFail("字段数 %d 与表头 %d 不一致", len(record), len(header))
+			continue
+		}
+		if keyIndex >= 0 && rowKey == "" {
+			rowFail("key 列 %s 为空", key)
+			continue
+		}
+
+		values := []interface{}{}
+		var valueErr error
+		for j, name := range header {
+			v, err := csvValue(types[strings.ToLower(name)], record[j])
+			if err != nil {
+				valueErr = fmt.Errorf("列 %s: %s", name, err.Error())
+				break
+			}
+			values = append(values, v)
+		}
+		if valueErr != nil {
+			rowFail("%s", valueErr.Error())
+			continue
+		}
+
+		if keyIndex >= 0 {
+			result, err := tx.Exec(update, append(values, values[keyIndex])...)
+			if err != nil {
+				rowFail("%s", err.Error())
+				continue
+			}
+			if n, _ := result.RowsAffected(); n > 0 {
+				report.Updated = append(report.Updated, line)
+				continue
+			}
+		}
+		if _, err := tx.Exec(insert, values...); err != nil {
+			rowFail("%s", err.Error())
+			continue
+		}
+		report.Inserted = append(report.Inserted, line)
+	}
+
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Line < failed[j].Line })
+	report.Failed = failed
+	report.Rollback = strict && len(failed) > 0
+	if report.Rollback {
+		tx.Rollback()
+	} else {
+		tx.Commit()
+	}
+
+	code := "0"
+	des := fmt.Sprintf("插入 %d 行, 更新 %d 行, 失败 %d 行", len(report.Inserted), len(report.Updated), len(report.Failed))
+	if report.Rollback {
+		code = "1"
+		des += ", 已全部回滚"
+	}
+	json.NewEncoder(w).Encode(resResultT{
+		Code:   code,
+		Des:    des,
+		Result: report,
+	})
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 通讯录: mock_user 中的工号, 姓名, 电话和公司, 以及 mock_role 中的角色
+
+type directoryUserT struct {
+	ID          string   `db:"id" json:"userId"`
+	Username    string   `db:"username" json:"username"`
+	Password    string   `db:"-" json:"password,omitempty"`
+	Eno         string   `db:"eno" json:"eno"`
+	DisplayName string   `db:"display_name" json:"displayName"`
+	Phone       string   `db:"phone" json:"phone"`
+	Company     string   `db:"company" json:"company"`
+	Roles       []string `db:"-" json:"roles"`
+	Created     string   `db:"created_at" json:"createdAt"`
+}
+
+type mockRoleT struct {
+	Name        string   `db:"name" json:"name"`
+	Description string   `db:"description" json:"description"`
+	Users       int      `db:"users" json:"users"`
+	Codes       []string `db:"-" json:"codes"`
+}
+
+const directoryColumns = "id, username, coalesce(eno, '') as eno, coalesce(display_name, '') as display_name, coalesce(phone, '') as phone, coalesce(company, '') as company, coalesce(created_at, '') as created_at"
+
+// directoryNames 通讯录中的姓名, 用于生成数据中的操作人
+func directoryNames(r *http.Request) []string {
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	names := []string{}
+	err = db.Select(&names, "select display_name from mock_user where coalesce(display_name, '')!='' order by eno")
+	if err != nil {
+		panic(err)
+	}
+	return names
+}
+
+func pickName(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return names[rand.Intn(len(names))]
+}
+
+// unknownRoles 不在 mock_role 中的角色
+func unknownRoles(db *sqlx.DB, roles []string) []string {
+	unknown := []string{}
+	for _, role := range roles {
+		var count int
+		err := db.Get(&count, "select count(*) from mock_role where name=$1", role)
+		if err != nil {
+			panic(err)
+		}
+		if count == 0 {
+			unknown = append(unknown, role)
+		}
+	}
+	return unknown
+}
+
+func roleErrors(roles []string) []string {
+	errors := []string{}
+	for _, role := range roles {
+		errors = append(errors, fmt.Sprintf("角色 %s 不存在", role))
+	}
+	return errors
+}
+
+// getEno 当前用户的工号
+func getEno(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	userID, ok := tokenUserID(w, r)
+	if !ok {
+		return
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	var eno string
+	err = db.Get(&eno, "select coalesce(eno, '') from mock_user where id=$1", userID)
+
+	db.Close()
+
+	if err == sql.ErrNoRows || eno == "" {
+		json.NewEncoder(w).Encode(codeRetT{
+			Code: "1",
+			Des:  "通讯录中没有该用户的工号",
+		})
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	json.NewEncoder(w).Encode(codeRetT{
+		Code:   "0",
+		Result: eno,
+		Des:    "fullfill the request!",
+	})
+}
+
+// MockUser 通讯录中的用户, GET 列出, POST 新增或修改, DELETE 删除
+func MockUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		keyword := "%" + r.URL.Query().Get("keyword") + "%"
+		users := []directoryUserT{}
+		err = db.Select(&users, "select "+directoryColumns+" from mock_user where username like $1 or display_name like $1 or eno like $1 or phone like $1 order by eno, username", keyword)
+		if err != nil {
+			panic(err)
+		}
+		for i := range users {
+			users[i].Roles = requestRoles(db, users[i].ID)
+		}
+		json.NewEncoder(w).Encode(resResultT{
+			Code:   "0",
+			Des:    "",
+			Result: users,
+		})
+	case http.MethodPost:
+		saveMockUser(w, db, r)
+	case http.MethodDelete:
+		userID := r.URL.Query().Get("userId")
+		if userID == "" {
+			panic("userId can not be null")
+		}
+
+		tx := db.MustBegin()
+		result := tx.MustExec("delete from mock_user where id=$1", userID)
+		tx.MustExec("delete from user_role where user_id=$1", userID)
+		tx.Commit()
+
+		if n, _ := result.RowsAffected(); n == 0 {
+			json.NewEncoder(w).Encode(resResultT{
+				Code: "1",
+				Des:  "用户不存在",
+			})
+			break
+		}
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "0",
+			Des:  "",
+		})
+	}
+
+	db.Close()
+}
+
+func saveMockUser(w http.ResponseWriter, db *sqlx.DB, r *http.Request) {
+	user := directoryUserT{}
+	err := json.NewDecoder(r.Body).Decode(&user)
+	if err != nil {
+		panic(err)
+	}
+
+	var exists int
+	err = db.Get(&exists, "select count(*) from mock_user where id=$1", user.ID)
+	if err != nil {
+		panic(err)
+	}
+
+	errors := []string{}
+	if user.ID == "" || user.Username == "" {
+		errors = append(errors, "userId 和 username 不能为空")
+	}
+	if exists == 0 && user.Password == "" {
+		errors = append(errors, "新用户的 password 不能为空")
+	}
+
+	var count int
+	err = db.Get(&count, "select count(*) from mock_user where username=$1 and id!=$2", user.Username, user.ID)
+	if err != nil {
+		panic(err)
+	}
+	if count > 0 {
+		errors = append(errors, "用户名已存在")
+	}
+	if user.Eno != "" {
+		err = db.Get(&count, "select count(*) from mock_user where eno=$1 and id!=$2", user.Eno, user.ID)
+		if err != nil {
+			panic(err)
+		}
+		if count > 0 {
+			errors = append(errors, fmt.Sprintf("工号 %s 已存在", user.Eno))
+		}
+	}
+	errors = append(errors, roleErrors(unknownRoles(db, user.Roles))...)
+
+	if len(errors) > 0 {
+		json.NewEncoder(w).Encode(resResultT{
+			Code:   "1",
+			Des:    "用户校验失败",
+			Result: errors,
+		})
+		return
+	}
+
+	tx := db.MustBegin()
+	if exists == 0 {
+		tx.MustExec("insert into mock_user(id, username, password_hash, eno, display_name, phone, company, created_at) values($1, $2, $3, $4, $5, $6, $7, $8)",
+			user.ID, user.Username, hashPassword(user.Password), user.Eno, user.DisplayName, user.Phone, user.Company, time.Now().Format("2006-01-02 15:04:05"))
+	} else {
+		tx.MustExec("update mock_user set username=$1, eno=$2, display_name=$3, phone=$4, company=$5 where id=$6",
+			user.Username, user.Eno, user.DisplayName, user.Phone, user.Company, user.ID)
+		if user.Password != "" {
+			tx.MustExec("update mock_user set password_hash=$1 where id=$2", hashPassword(user.Password), user.ID)
+		}
+	}
+
+	// 没有提交 roles 时保留原来的角色
+	if user.Roles != nil {
+		tx.MustExec("delete from user_role where user_id=$1", user.ID)
+		for i, role := range user.Roles {
+			tx.MustExec("insert or ignore into user_role(user_id, role, seq) values($1, $2, $3)", user.ID, role, i)
+		}
+	}
+	tx.Commit()
+
+	json.NewEncoder(w).Encode(resResultT{
+		Code: "0",
+		Des:  "",
+	})
+}
+
+// MockRole 角色, GET 列出, POST 新增或修改说明, DELETE 删除角色及其布局和权限
+func MockRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		roles := []mockRoleT{}
+		err = db.Select(&roles, `
+						select name, coalesce(description, '') as description,
+						(select count(*) from user_role where role=mock_role.name) as users
+						from mock_role order by name
+						`)
+		if err != nil {
+			panic(err)
+		}
+		for i := range roles {
+			roles[i].Codes = roleCodes(db, []string{roles[i].Name})
+		}
+		json.NewEncoder(w).Encode(resResultT{
+			Code:   "0",
+			Des:    "",
+			Result: roles,
+		})
+	case http.MethodPost:
+		role := mockRoleT{}
+		err = json.NewDecoder(r.Body).Decode(&role)
+		if err != nil {
+			panic(err)
+		}
+
+		role.Name = strings.TrimSpace(role.Name)
+		if role.Name == "" {
+			json.NewEncoder(w).Encode(resResultT{
+				Code: "1",
+				Des:  "角色名称不能为空",
+			})
+			break
+		}
+
+		db.MustExec("insert into mock_role(name, description, created_at) values($1, $2, $3) on conflict(name) do update set description=excluded.description",
+			role.Name, role.Description, time.Now().Format("2006-01-02 15:04:05"))
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "0",
+			Des:  "",
+		})
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			panic("name can not be null")
+		}
+		if name == "admin" {
+			json.NewEncoder(w).Encode(resResultT{
+				Code: "1",
+				Des:  "admin 角色不能删除",
+			})
+			break
+		}
+
+		tx := db.MustBegin()
+		result := tx.MustExec("delete from mock_role where name=$1", name)
+		for _, table := range []string{"user_role", "role_permission", "role_column", "role_filter"} {
+			tx.MustExec("delete from "+table+" where role=$1", name)
+		}
+		tx.Commit()
+
+		if n, _ := result.RowsAffected(); n == 0 {
+			json.NewEncoder(w).Encode(resResultT{
+				Code: "1",
+				Des:  "角色不存在",
+			})
+			break
+		}
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "0",
+			Des:  "",
+		})
+	}
+
+	db.Close()
+}
+
+func columnExists(tx *sqlx.Tx, table, column string) bool {
+	var count int
+	err := tx.Get(&count, "select count(*) from pragma_table_info($1) where name=$2", table, column)
+	if err != nil {
+		panic(err)
+	}
+	return count > 0
+}
+
+// seedDirectory 给已有的 mock_user 补上通讯录字段, 并把已经在用的角色写入 mock_role
+func seedDirectory(tx *sqlx.Tx) {
+	for _, column := range []string{"eno", "display_name", "phone", "company"} {
+		if !columnExists(tx, "mock_user", column) {
+			tx.MustExec("alter table mock_user add column " + column + " VARCHAR(255)")
+		}
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	users := []directoryUserT{
+		{ID: "0013", Username: "username", Password: "password", Eno: "0009", DisplayName: "陈科宇", Phone: "13800000009", Company: "上海欧恒进出口贸易有限公司"},
+		{ID: "0014", Username: "guest", Password: "guest", Eno: "0010", DisplayName: "访客", Phone: "13800000010", Company: "上海欧恒进出口贸易有限公司"},
+		{ID: "0015", Username: "limeng", Password: "password", Eno: "0011", DisplayName: "李梦", Phone: "13800000011", Company: "上海欧恒进出口贸易有限公司"},
+		{ID: "0016", Username: "wangwei", Password: "password", Eno: "0012", DisplayName: "王伟", Phone: "13800000012", Company: "上海欧恒进出口贸易有限公司"},
+	}
+	for _, u := range users {
+		tx.MustExec("insert or ignore into mock_user(id, username, password_hash, created_at) values($1, $2, $3, $4)",
+			u.ID, u.Username, hashPassword(u.Password), now)
+		tx.MustExec("update mock_user set eno=$1, display_name=$2, phone=$3, company=$4 where id=$5 and coalesce(eno, '')=''",
+			u.Eno, u.DisplayName, u.Phone, u.Company, u.ID)
+	}
+
+	tx.MustExec("insert or ignore into mock_role(name, description, created_at) values('admin', '管理员', $1)", now)
+	for _, table := range []string{"user_role", "role_permission", "role_column", "role_filter"} {
+		tx.MustExec("insert or ignore into mock_role(name, description, created_at) select distinct role, '', $1 from "+table+" where coalesce(role, '')!=''", now)
+	}
+}
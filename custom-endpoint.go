@@ -0,0 +1,300 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 可以和模块关联的数据接口, 返回生成的行
+var endpointRows = map[string]func(*http.Request) interface{}{
+	"/market/list":                                      func(r *http.Request) interface{} { return marketRows(r) },
+	"/market/customer/search":                           func(r *http.Request) interface{} { return searchRows(r) },
+	"/market/out-application/list":                      func(r *http.Request) interface{} { return outApplicationRows(r) },
+	"/market/plugin-application/list":                   func(r *http.Request) interface{} { return pluginApplicationRows(r) },
+	"/market/settlement/list":                           func(r *http.Request) interface{} { return settlementRows(r) },
+	"/market/lau/list":                                  func(r *http.Request) interface{} { return lauRows(r) },
+	"/market_hgx/hgxForklift!queryForkliftSelect.dhtml": func(r *http.Request) interface{} { return fleetRows(r) },
+}
+
+// personEndpoint /data/person 从数据库查询, 不在 endpointRows 中, 同样可以关联模块
+const personEndpoint = "/data/person"
+
+// linkableEndpoint 可以和模块关联的接口
+func linkableEndpoint(endpoint string) bool {
+	_, ok := endpointRows[endpoint]
+	return ok || endpoint == personEndpoint
+}
+
+type moduleEndpointT struct {
+	MoudleID string `db:"module_id" json:"moduleId"`
+	Endpoint string `db:"endpoint" json:"endpoint"`
+}
+
+// rowMaps 把生成的行转成以 json 字段名为 key 的 map, 过滤按 raw_filter.value 匹配字段
+func rowMaps(rows interface{}) []map[string]interface{} {
+	b, err := json.Marshal(rows)
+	if err != nil {
+		panic(err)
+	}
+
+	maps := []map[string]interface{}{}
+	err = json.Unmarshal(b, &maps)
+	if err != nil {
+		panic(err)
+	}
+	return maps
+}
+
+// requestModule 请求中的 moduleId, 没有时使用唯一关联到该接口的模块
+func requestModule(db *sqlx.DB, r *http.Request) string {
+	if moduleID := r.Form.Get("moduleId"); moduleID != "" {
+		return moduleID
+	}
+
+	moduleIDs := []string{}
+	err := db.Select(&moduleIDs, "select module_id from module_endpoint where endpoint=$1", r.URL.Path)
+	if err != nil {
+		panic(err)
+	}
+	if len(moduleIDs) == 1 {
+		return moduleIDs[0]
+	}
+	return ""
+}
+
+// requestFilterValues 读取 filters 参数中的 json, 以及直接以 raw_filter.value 为 key 提交的值
+func requestFilterValues(r *http.Request, filters []rawFilter) (map[string]searchValueT, error) {
+	values := make(map[string]searchValueT)
+	if str := r.Form.Get("filters"); str != "" {
+		err := json.Unmarshal([]byte(str), &values)
+		if err != nil {
+			return nil, fmt.Errorf("filters 参数格式错误: %s", err.Error())
+		}
+	}
+
+	for _, f := range filters {
+		if _, ok := values[f.Value]; ok {
+			continue
+		}
+		if v := r.Form.Get(f.Value); v != "" {
+			values[f.Value] = searchValueT{Value: v}
+		}
+	}
+	return values, nil
+}
+
+// applyModuleFilters 按模块的过滤定义筛选行, 没有关联模块时原样返回
+func applyModuleFilters(r *http.Request, rows interface{}) ([]map[string]interface{}, error) {
+	maps := rowMaps(rows)
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	moduleID := requestModule(db, r)
+	if moduleID == "" {
+		return maps, nil
+	}
+
+	filters := maintenanceFilters(db, moduleID)
+	values, err := requestFilterValues(r, filters)
+	if err != nil {
+		return nil, err
+	}
+	if errors := checkSearchValues(filters, values); len(errors) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errors, "; "))
+	}
+
+	definitions := make(map[string]rawFilter)
+	for _, f := range filters {
+		definitions[f.Value] = f
+	}
+
+	result := []map[string]interface{}{}
+	for _, row := range maps {
+		matched := true
+		for value, v := range values {
+			if v.Value == "" {
+				continue
+			}
+			f := definitions[value]
+			op := v.Operator
+			if op == "" {
+				op = strings.Split(filterOperators(f.Type, f.Operators), ",")[0]
+			}
+			if !matchFilter(f.Type, row[value], op, v.Value) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			result = append(result, row)
+		}
+	}
+	return result, nil
+}
+
+// compareFilterValue 都是数字时按数字比较, 否则按字符串比较; 日期只比较到条件的精度
+func compareFilterValue(t, cell, value string) int {
+	a, errA := strconv.ParseFloat(cell, 64)
+	b, errB := strconv.ParseFloat(value, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	}
+
+	if t == "date-range" && len(cell) > len(value) {
+		cell = cell[:len(value)]
+	}
+	return strings.Compare(cell, value)
+}
+
+func matchFilter(t string, cell interface{}, op, value string) bool {
+	str := ""
+	if cell != nil {
+		str = fmt.Sprint(cell)
+	}
+
+	// 级联提交的是路径, 只比较最后一级
+	if t == "cascader" && op == "eq" {
+		path := strings.Split(value, ",")
+		value = path[len(path)-1]
+	}
+
+	switch op {
+	case "eq":
+		return compareFilterValue(t, str, value) == 0
+	case "ne":
+		return compareFilterValue(t, str, value) != 0
+	case "contains":
+		return strings.Contains(str, value)
+	case "prefix":
+		return strings.HasPrefix(str, value)
+	case "gt":
+		return compareFilterValue(t, str, value) > 0
+	case "gte":
+		return compareFilterValue(t, str, value) >= 0
+	case "lt":
+		return compareFilterValue(t, str, value) < 0
+	case "lte":
+		return compareFilterValue(t, str, value) <= 0
+	case "between":
+		bounds := strings.SplitN(value, ",", 2)
+		if bounds[0] != "" && compareFilterValue(t, str, bounds[0]) < 0 {
+			return false
+		}
+		if len(bounds) == 2 && bounds[1] != "" && compareFilterValue(t, str, bounds[1]) > 0 {
+			return false
+		}
+		return true
+	case "in":
+		return sliceString(strings.Split(value, ",")).search(str)
+	case "nin":
+		return !sliceString(strings.Split(value, ",")).search(str)
+	}
+	return false
+}
+
+// ModuleEndpoint 模块和数据接口的关联
+func ModuleEndpoint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		endpoints := []moduleEndpointT{}
+		err = db.Select(&endpoints, "select module_id, endpoint from module_endpoint where $1='' or module_id=$1 order by module_id", id)
+		if err != nil {
+			panic(err)
+		}
+
+		available := []string{personEndpoint}
+		for endpoint := range endpointRows {
+			available = append(available, endpoint)
+		}
+		sort.Strings(available)
+
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "0",
+			Des:  "",
+			Result: map[string]interface{}{
+				"links":     endpoints,
+				"endpoints": available,
+			},
+		})
+	case http.MethodPost:
+		param := moduleEndpointT{}
+		err = json.NewDecoder(r.Body).Decode(&param)
+		if err != nil {
+			panic(err)
+		}
+
+		if id == "" {
+			panic("id can not be null")
+		}
+
+		if !linkableEndpoint(param.Endpoint) {
+			json.NewEncoder(w).Encode(resResultT{
+				Code: "1",
+				Des:  fmt.Sprintf("接口 %s 不支持关联", param.Endpoint),
+			})
+			break
+		}
+
+		db.MustExec("insert or replace into module_endpoint(module_id, endpoint) values($1, $2)", id, param.Endpoint)
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "0",
+			Des:  "",
+		})
+	case http.MethodDelete:
+		if id == "" {
+			panic("id can not be null")
+		}
+
+		var endpoint string
+		err = db.Get(&endpoint, "select endpoint from module_endpoint where module_id=$1", id)
+		if err == sql.ErrNoRows {
+			json.NewEncoder(w).Encode(resResultT{
+				Code: "1",
+				Des:  "模块没有关联接口",
+			})
+			break
+		} else if err != nil {
+			panic(err)
+		}
+
+		db.MustExec("delete from module_endpoint where module_id=$1", id)
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "0",
+			Des:  "",
+		})
+	}
+
+	db.Close()
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 导出 /data/person 和生成的市场列表, 按用户合并后的布局输出 CSV 或 JSON Lines.
+// 隐藏的列 (以及隐藏分组下的列) 不导出, 顺序按 seq, 表头使用 name
+
+// 每写出多少行刷新一次
+const exportFlushRows = 500
+
+type exportColumnT struct {
+	Value string
+	Name  string
+}
+
+// Person 的 json 字段, 按结构体中的顺序
+var personFields = []string{"id", "firstName", "lastName", "email", "gender", "IPaddr", "city", "country", "latitude", "longitude", "guid"}
+
+// exportColumns 合并后的布局中可见的叶子列
+func exportColumns(columns []userColumn) []exportColumnT {
+	result := []exportColumnT{}
+	var walk func(columns []userColumn)
+	walk = func(columns []userColumn) {
+		for _, c := range columns {
+			if c.Hidden == "1" {
+				continue
+			}
+			if len(c.Children) > 0 {
+				walk(c.Children)
+				continue
+			}
+			result = append(result, exportColumnT{Value: c.Value, Name: c.Name})
+		}
+	}
+	walk(columnTree(columns))
+	return result
+}
+
+func fieldColumns(fields []string) []exportColumnT {
+	columns := []exportColumnT{}
+	for _, f := range fields {
+		columns = append(columns, exportColumnT{Value: f, Name: f})
+	}
+	return columns
+}
+
+func exportCell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// exportWriter 按格式逐行写出, 定期 flush
+type exportWriter struct {
+	w       http.ResponseWriter
+	csv     *csv.Writer
+	columns []exportColumnT
+	rows    int
+}
+
+func newExportWriter(w http.ResponseWriter, format, filename string, columns []exportColumnT) *exportWriter {
+	ew := &exportWriter{w: w, columns: columns}
+	if format == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.jsonl"`)
+		return ew
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+	// Excel 需要 BOM 才能识别中文表头
+	io.WriteString(w, "\ufeff")
+	ew.csv = csv.NewWriter(w)
+	header := []string{}
+	for _, c := range columns {
+		header = append(header, c.Name)
+	}
+	ew.csv.Write(header)
+	return ew
+}
+
+func (ew *exportWriter) write(row map[string]interface{}) error {
+	if ew.csv != nil {
+		record := []string{}
+		for _, c := range ew.columns {
+			record = append(record, exportCell(row[c.Value]))
+		}
+		if err := ew.csv.Write(record); err != nil {
+			return err
+		}
+	} else {
+		// 手动拼接以保持列的顺序
+		var buf bytes.Buffer
+		buf.WriteString("{")
+		for i, c := range ew.columns {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			k, _ := json.Marshal(c.Name)
+			value := row[c.Value]
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+			v, _ := json.Marshal(value)
+			buf.Write(k)
+			buf.WriteString(":")
+			buf.Write(v)
+		}
+		buf.WriteString("}\n")
+		if _, err := ew.w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	ew.rows++
+	if ew.rows%exportFlushRows == 0 {
+		ew.flush()
+	}
+	return nil
+}
+
+func (ew *exportWriter) flush() {
+	if ew.csv != nil {
+		ew.csv.Flush()
+	}
+	if f, ok := ew.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// sortRows 按 sort 参数排序生成的行, 格式同 /data/person
+func sortRows(rows []map[string]interface{}, param string) {
+	keys := []string{}
+	for _, item := range strings.Split(param, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			keys = append(keys, item)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, key := range keys {
+			field := strings.TrimPrefix(key, "-")
+			c := compareFilterValue("", exportCell(rows[i][field]), exportCell(rows[j][field]))
+			if c == 0 {
+				continue
+			}
+			if strings.HasPrefix(key, "-") {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// ExportData 导出数据, 参数 source 为数据接口, moduleId 为模块, format 为 csv 或 jsonl, 其余参数同数据接口
+func ExportData(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Disposition")
+
+	userID, ok := tokenUserID(w, r)
+	if !ok {
+		return
+	}
+
+	r.ParseForm()
+	source := r.Form.Get("source")
+	format := r.Form.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+
+	fail := func(des string) {
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "1",
+			Des:  des,
+		})
+	}
+
+	if format != "csv" && format != "jsonl" {
+		fail(fmt.Sprintf("不支持的格式 %s", format))
+		return
+	}
+	if !linkableEndpoint(source) {
+		fail(fmt.Sprintf("接口 %s 不支持导出", source))
+		return
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	// 没有指定模块时使用唯一关联到该接口的模块. id 是 person 的字段, 模块只能用 moduleId 指定
+	id := r.Form.Get("moduleId")
+	if id == "" {
+		moduleIDs := []string{}
+		err = db.Select(&moduleIDs, "select module_id from module_endpoint where endpoint=$1", source)
+		if err != nil {
+			panic(err)
+		}
+		if len(moduleIDs) == 1 {
+			id = moduleIDs[0]
+		}
+	}
+
+	var columns []exportColumnT
+	if id != "" {
+		role := layoutRole(db, requestRoles(db, userID), "role_column", id)
+		columns = exportColumns(userMaintenanceColumns(db, userID, role, id))
+	}
+
+	filename := path.Base(source) + "-" + time.Now().Format("20060102150405")
+
+	if source == personEndpoint {
+		q, err := requestPersonQuery(r)
+		if err != nil {
+			fail(err.Error())
+			return
+		}
+		if len(columns) == 0 {
+			columns = fieldColumns(personFields)
+		}
+
+		dbColumns := make(map[string]string)
+		for field, column := range personColumns {
+			dbColumns[column] = field
+		}
+
+		rows, err := db.Queryx("select * from person"+q.whereSQL()+q.orderSQL(), q.Args...)
+		if err != nil {
+			panic(err)
+		}
+		defer rows.Close()
+
+		ew := newExportWriter(w, format, filename, columns)
+		for rows.Next() {
+			values := make(map[string]interface{})
+			if err := rows.MapScan(values); err != nil {
+				panic(err)
+			}
+			row := make(map[string]interface{})
+			for column, v := range values {
+				row[dbColumns[column]] = v
+			}
+			if ew.write(row) != nil {
+				// 客户端已断开
+				return
+			}
+		}
+		ew.flush()
+		return
+	}
+
+	// 生成的列表和列表接口一样按模块过滤, 按规则计算按钮状态, 然后排序
+	r.Form.Set("moduleId", id)
+	rows, err := applyModuleFilters(r, endpointRows[source](r))
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	applyActionRules(r, source, rows)
+	sortRows(rows, r.Form.Get("sort"))
+
+	if len(columns) == 0 {
+		fields := []string{}
+		if len(rows) > 0 {
+			for field := range rows[0] {
+				fields = append(fields, field)
+			}
+		}
+		sort.Strings(fields)
+		columns = fieldColumns(fields)
+	}
+
+	ew := newExportWriter(w, format, filename, columns)
+	for _, row := range rows {
+		if ew.write(row) != nil {
+			return
+		}
+	}
+	ew.flush()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 过滤类型及其允许的操作符, 第一个为默认操作符
+var filterTypes = map[string][]string{
+	"text":         {"contains", "eq", "ne", "prefix"},
+	"number-range": {"between", "eq", "ne", "gt", "gte", "lt", "lte"},
+	"date-range":   {"between", "eq", "gt", "gte", "lt", "lte"},
+	"select":       {"eq", "ne", "in"},
+	"multi-select": {"in", "nin"},
+	"cascader":     {"eq", "in"},
+}
+
+type filterSourceT struct {
+	File string
+	Tree bool
+}
+
+// 选项来源, 只能使用已有的数据源
+var filterOptionSources = map[string]filterSourceT{
+	"/drop-down/ds": {File: "bank.json"},
+	"/cascade/ds":   {File: "account.json", Tree: true},
+}
+
+// filterType 旧数据没有类型, 按文本处理
+func filterType(t string) string {
+	if t == "" {
+		return "text"
+	}
+	return t
+}
+
+// filterOperators 没有设置操作符时返回该类型全部操作符
+func filterOperators(t, operators string) string {
+	if operators == "" {
+		return strings.Join(filterTypes[filterType(t)], ",")
+	}
+	return operators
+}
+
+// checkFilterDefinition 校验类型, 操作符, 选项来源和默认值
+func checkFilterDefinition(tenant, t, operators, defaultValue, source string) error {
+	t = filterType(t)
+	allowed, ok := filterTypes[t]
+	if !ok {
+		return fmt.Errorf("未知的过滤类型 %s", t)
+	}
+
+	if operators != "" {
+		for _, op := range strings.Split(operators, ",") {
+			if !sliceString(allowed).search(op) {
+				return fmt.Errorf("类型 %s 不支持操作符 %s", t, op)
+			}
+		}
+	}
+
+	needSource := t == "select" || t == "multi-select" || t == "cascader"
+	if !needSource {
+		if source != "" {
+			return fmt.Errorf("类型 %s 不需要选项来源", t)
+		}
+	} else {
+		s, ok := filterOptionSources[source]
+		if !ok {
+			return fmt.Errorf("选项来源 %q 不存在", source)
+		}
+		if s.Tree != (t == "cascader") {
+			return fmt.Errorf("选项来源 %s 不能用于类型 %s", source, t)
+		}
+	}
+
+	if defaultValue == "" {
+		return nil
+	}
+
+	values := strings.Split(defaultValue, ",")
+	switch t {
+	case "number-range":
+		if len(values) > 2 {
+			return fmt.Errorf("默认值 %s 应为 最小值,最大值", defaultValue)
+		}
+		for _, v := range values {
+			if _, err := strconv.ParseFloat(strings.TrimSpace(v), 64); v != "" && err != nil {
+				return fmt.Errorf("默认值 %s 不是数字", v)
+			}
+		}
+	case "date-range":
+		if len(values) > 2 {
+			return fmt.Errorf("默认值 %s 应为 开始日期,结束日期", defaultValue)
+		}
+		for _, v := range values {
+			if _, err := time.Parse("2006-01-02", strings.TrimSpace(v)); v != "" && err != nil {
+				return fmt.Errorf("默认值 %s 不是日期", v)
+			}
+		}
+	case "select", "multi-select", "cascader":
+		if t == "select" && len(values) > 1 {
+			return fmt.Errorf("单选的默认值只能有一个")
+		}
+		options, err := filterOptionValues(tenant, source)
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			if !options.search(v) {
+				return fmt.Errorf("默认值 %s 不在选项来源 %s 中", v, source)
+			}
+		}
+	}
+	return nil
+}
+
+// filterOptionValues 读取选项来源中全部的 value, 级联的逐层展开
+func filterOptionValues(tenant, source string) (sliceString, error) {
+	type optionT struct {
+		Value    string    `json:"value"`
+		Children []optionT `json:"children"`
+	}
+
+	plan, err := ioutil.ReadFile(tenantFile(tenant, filterOptionSources[source].File))
+	if err != nil {
+		return nil, err
+	}
+
+	options := []optionT{}
+	err = json.Unmarshal(plan, &options)
+	if err != nil {
+		return nil, err
+	}
+
+	values := sliceString{}
+	var walk func(options []optionT)
+	walk = func(options []optionT) {
+		for _, o := range options {
+			values = append(values, o.Value)
+			walk(o.Children)
+		}
+	}
+	walk(options)
+	return values, nil
+}
@@ -4,29 +4,38 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/rs/xid"
 )
 
 type rawFilter struct {
-	Name     string `db:"name" json:"name"`
-	Value    string `db:"value" json:"value"`
-	MoudleID string `db:"module_id" json:"moduleId"`
-	Fixed    string `db:"fixed" json:"fixed"`
-	ID       string `db:"id" json:"id"`
-	Status   string `json:"status"`
+	Name          string `db:"name" json:"name"`
+	Value         string `db:"value" json:"value"`
+	MoudleID      string `db:"module_id" json:"moduleId"`
+	Fixed         string `db:"fixed" json:"fixed"`
+	ID            string `db:"id" json:"id"`
+	Type          string `db:"type" json:"type"`
+	Operators     string `db:"operators" json:"operators"`
+	DefaultValue  string `db:"default_value" json:"defaultValue"`
+	OptionsSource string `db:"options_source" json:"optionsSource"`
+	Status        string `json:"status"`
 }
 
 type userFilter struct {
-	Name   string `db:"name" json:"name"`
-	Value  string `db:"value" json:"value"`
-	Fixed  string `db:"fixed" json:"fixed"`
-	Hidden string `db:"hidden" json:"hidden"`
-	ID     string `db:"id" json:"id"`
-	Status string `json:"status" json:"-"`
-	Seq    string `db:"seq" json:"-"`
+	Name          string        `db:"name" json:"name"`
+	Value         string        `db:"value" json:"value"`
+	Fixed         string        `db:"fixed" json:"fixed"`
+	Hidden        string        `db:"hidden" json:"hidden"`
+	ID            string        `db:"id" json:"id"`
+	Type          string        `db:"type" json:"type"`
+	Operators     string        `db:"operators" json:"operators"`
+	DefaultValue  string        `db:"default_value" json:"defaultValue"`
+	OptionsSource string        `db:"options_source" json:"optionsSource"`
+	Saved         *searchValueT `json:"saved,omitempty"`
+	Status        string        `json:"status" json:"-"`
+	Seq           string        `db:"seq" json:"-"`
 }
 
 // GetMaintenanceFilter 运维表数据
@@ -44,7 +53,7 @@ func GetMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
 
 func getMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
 
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -55,22 +64,39 @@ func getMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
 		id = ids[0]
 	}
 
-	filters := []rawFilter{}
-	err = db.Select(&filters, "select id, name, value, fixed from `raw_filter` where module_id = $1 order by seq", id)
-	if err != nil {
-		panic(err)
-	}
+	filters := maintenanceFilters(db, id)
+	revision := moduleRevision(db, id, "filter")
 
 	db.Close()
 
-	res := resResultT{
-		Code:   "0",
-		Des:    "",
-		Result: filters,
+	setRevisionHeader(w, revision)
+	res := revisionResultT{
+		Code:     "0",
+		Des:      "",
+		Result:   filters,
+		Revision: revision,
 	}
 	json.NewEncoder(w).Encode(res)
 }
 
+func maintenanceFilters(db *sqlx.DB, id string) []rawFilter {
+	filters := []rawFilter{}
+	err := db.Select(&filters, `
+						select id, name, value, fixed, coalesce(type, '') as type,
+						coalesce(operators, '') as operators,
+						coalesce(default_value, '') as default_value,
+						coalesce(options_source, '') as options_source
+						from raw_filter where module_id = $1 order by seq
+						`, id)
+	if err != nil {
+		panic(err)
+	}
+	for i := range filters {
+		filters[i].Type = filterType(filters[i].Type)
+	}
+	return filters
+}
+
 func updateMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	filters := []rawFilter{}
@@ -79,7 +105,34 @@ func updateMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
 		panic(err)
 	}
 
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+	type definitionErrorT struct {
+		Value string `json:"value"`
+		Error string `json:"error"`
+	}
+
+	definitionErrors := []definitionErrorT{}
+	for i, datum := range filters {
+		if datum.Status != "0" && datum.Status != "1" {
+			continue
+		}
+		filters[i].Type = filterType(datum.Type)
+		if err := checkFilterDefinition(requestTenant(r).ID, datum.Type, datum.Operators, datum.DefaultValue, datum.OptionsSource); err != nil {
+			definitionErrors = append(definitionErrors, definitionErrorT{
+				Value: datum.Value,
+				Error: err.Error(),
+			})
+		}
+	}
+	if len(definitionErrors) > 0 {
+		json.NewEncoder(w).Encode(resResultT{
+			Code:   "1",
+			Des:    "过滤定义校验失败",
+			Result: definitionErrors,
+		})
+		return
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		panic(err)
 	}
@@ -90,23 +143,67 @@ func updateMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
 		id = ids[0]
 	}
 
+	revision, ok := requestRevision(r)
+	if !ok {
+		db.Close()
+		writeRevisionRequired(w)
+		return
+	}
+
 	tx := db.MustBegin()
 
+	if !bumpModuleRevision(tx, id, "filter", revision) {
+		tx.Rollback()
+		current := maintenanceFilters(db, id)
+		currentRevision := moduleRevision(db, id, "filter")
+		db.Close()
+		writeRevisionConflict(w, currentRevision, current)
+		return
+	}
+
+	// 删除只作用于当前模块, 同时删除用户在该模块上的个性化设置
+	type removedT struct {
+		Value    string `json:"value"`
+		Rows     int    `json:"rows"`
+		UserRows int64  `json:"userRows"`
+	}
+	removed := []removedT{}
+
+	actor := requestActor(r)
 	for i, datum := range filters {
 		if datum.Status == "0" {
-			tx.MustExec("insert into raw_filter(name, value, module_id, fixed, seq, id) values($1,$2,$3,$4,$5, $6)", datum.Name, datum.Value, id, datum.Fixed, i, xid.New().String())
+			rowID := xid.New().String()
+			tx.MustExec("insert into raw_filter(name, value, module_id, fixed, seq, id, type, operators, default_value, options_source) values($1,$2,$3,$4,$5, $6, $7, $8, $9, $10)", datum.Name, datum.Value, id, datum.Fixed, i, rowID, datum.Type, datum.Operators, datum.DefaultValue, datum.OptionsSource)
+			recordHistory(tx, id, "filter", revision+1, actor, "insert", rowID, nil, snapshotRow(tx, "raw_filter", rowID))
 		} else if datum.Status == "1" {
-			tx.MustExec("update `raw_filter` set name=$1, value=$2, fixed=$3, seq=$4 where module_id=$5 and id=$6", datum.Name, datum.Value, datum.Fixed, i, id, datum.ID)
+			before := snapshotRow(tx, "raw_filter", datum.ID)
+			tx.MustExec("update `raw_filter` set name=$1, value=$2, fixed=$3, seq=$4, type=$5, operators=$6, default_value=$7, options_source=$8 where module_id=$9 and id=$10", datum.Name, datum.Value, datum.Fixed, i, datum.Type, datum.Operators, datum.DefaultValue, datum.OptionsSource, id, datum.ID)
+			recordHistory(tx, id, "filter", revision+1, actor, "update", datum.ID, before, snapshotRow(tx, "raw_filter", datum.ID))
 		} else if datum.Status == "2" {
-			tx.MustExec("delete from `raw_filter` where value=$1", datum.Value)
+			deleted := snapshotRows(tx, "raw_filter", "value=$1 and module_id=$2", datum.Value, id)
+			tx.MustExec("delete from `raw_filter` where value=$1 and module_id=$2", datum.Value, id)
+			userRows := deleteCustomizations(tx, id, "filter", revision+1, actor, datum.Value)
+			if len(deleted) > 0 || userRows > 0 {
+				removed = append(removed, removedT{
+					Value:    datum.Value,
+					Rows:     len(deleted),
+					UserRows: userRows,
+				})
+			}
+			for _, before := range deleted {
+				recordHistory(tx, id, "filter", revision+1, actor, "delete", before["id"].(string), before, nil)
+			}
 		}
 	}
 	tx.Commit()
 	db.Close()
 
-	res := resResultT{
-		Code: "0",
-		Des:  "",
+	setRevisionHeader(w, revision+1)
+	res := revisionResultT{
+		Code:     "0",
+		Des:      "",
+		Result:   map[string]interface{}{"removed": removed},
+		Revision: revision + 1,
 	}
 	json.NewEncoder(w).Encode(res)
 }
@@ -138,20 +235,24 @@ func updateUserMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
 		id = ids[0]
 	}
 
-	token := r.Header.Get("token")
+	userID, ok := tokenUserID(w, r)
+	if !ok {
+		return
+	}
 
-	if id == "" || token == "" {
+	if id == "" || userID == "" {
 		panic("id or token can not be null")
 	}
 
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		panic(err)
 	}
 
+	now := time.Now().Format("2006-01-02 15:04:05")
 	tx := db.MustBegin()
 	allUserValues := sliceString{}
-	err = db.Select(&allUserValues, "select value from user_filter where user_id=$1", token)
+	err = db.Select(&allUserValues, "select value from user_filter where user_id=$1 and module_id=$2", userID, id)
 	if err != nil {
 		panic(err)
 	}
@@ -160,9 +261,9 @@ func updateUserMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
 
 		if datum.Status == "1" {
 			if allUserValues.search(datum.Value) {
-				tx.MustExec("update user_filter set hidden=$1, seq=$2 where module_id=$3 and user_id=$4 and value=$5", datum.Hidden, i, id, token, datum.Value)
+				tx.MustExec("update user_filter set hidden=$1, seq=$2, updated_at=$3 where module_id=$4 and user_id=$5 and value=$6", datum.Hidden, i, now, id, userID, datum.Value)
 			} else {
-				tx.MustExec("insert into user_filter(module_id, user_id, value, hidden, seq, id) values($1,$2,$3,$4,$5, $6)", id, token, datum.Value, datum.Hidden, i, xid.New().String())
+				tx.MustExec("insert into user_filter(module_id, user_id, value, hidden, seq, id, updated_at) values($1,$2,$3,$4,$5, $6, $7)", id, userID, datum.Value, datum.Hidden, i, xid.New().String(), now)
 			}
 		}
 	}
@@ -178,7 +279,7 @@ func updateUserMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
 }
 
 func getUserMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -189,36 +290,25 @@ func getUserMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
 		id = ids[0]
 	}
 
-	token := r.Header.Get("token")
+	userID, ok := tokenUserID(w, r)
+	if !ok {
+		db.Close()
+		return
+	}
 
-	if id == "" || token == "" {
+	if id == "" || userID == "" {
 		panic("id or token is nil")
 	}
 
-	filter := []userFilter{}
-	err = db.Select(&filter, `
-						select name, fixed, raw_filter.value,
-						case when user_filter.seq is null
-                        then raw_filter.seq else user_filter.seq
-                        end as seq,
-                        case when hidden is null
-                        then '0' else hidden
-                        end as hidden,
-                        case when user_filter.id is null
-                        then raw_filter.id else user_filter.id
-                        end as id
-                        from raw_filter left join (
-							select hidden, id,
-							user_id, value, module_id, seq from user_filter where user_id=$1
-                        ) as user_filter
-                        on raw_filter.module_id=user_filter.module_id 
-                        and raw_filter.value=user_filter.value 
-						where raw_filter.module_id=$2
-						order by seq asc
-						`, token, id)
+	role := layoutRole(db, requestRoles(db, userID), "role_filter", id)
+	filter := userMaintenanceFilters(db, userID, role, id)
 
-	if err != nil {
-		panic(err)
+	// 默认查询的值在加载时直接带上
+	saved := defaultSearchValues(db, userID, id)
+	for i := range filter {
+		if v, ok := saved[filter[i].Value]; ok {
+			filter[i].Saved = &v
+		}
 	}
 
 	db.Close()
@@ -231,11 +321,54 @@ func getUserMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+// userMaintenanceFilters 按 用户 -> 角色 -> 模块 的顺序合并过滤设置
+func userMaintenanceFilters(db *sqlx.DB, userID, role, id string) []userFilter {
+	filter := []userFilter{}
+	err := db.Select(&filter, `
+						select name, fixed, raw_filter.value,
+						coalesce(type, '') as type,
+						coalesce(operators, '') as operators,
+						coalesce(default_value, '') as default_value,
+						coalesce(options_source, '') as options_source,
+						case when user_filter.seq is not null then user_filter.seq
+						when role_filter.seq is not null then role_filter.seq
+						else raw_filter.seq end as seq,
+						case when user_filter.hidden is not null then user_filter.hidden
+						when role_filter.hidden is not null then role_filter.hidden
+						else '0' end as hidden,
+						case when user_filter.id is not null then user_filter.id
+						when role_filter.id is not null then role_filter.id
+						else raw_filter.id end as id
+						from raw_filter left join (
+							select hidden, id, value, module_id, seq from role_filter where role=$1
+						) as role_filter
+						on raw_filter.module_id=role_filter.module_id
+						and raw_filter.value=role_filter.value
+						left join (
+							select hidden, id,
+							user_id, value, module_id, seq from user_filter where user_id=$2 and user_id!=''
+						) as user_filter
+						on raw_filter.module_id=user_filter.module_id
+						and raw_filter.value=user_filter.value
+						where raw_filter.module_id=$3
+						order by seq asc
+						`, role, userID, id)
+
+	if err != nil {
+		panic(err)
+	}
+	for i := range filter {
+		filter[i].Type = filterType(filter[i].Type)
+		filter[i].Operators = filterOperators(filter[i].Type, filter[i].Operators)
+	}
+	return filter
+}
+
 // ResetUserMaintenanceFilter 重置用户过滤数据
 func ResetUserMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -246,14 +379,18 @@ func ResetUserMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
 		id = ids[0]
 	}
 
-	token := r.Header.Get("token")
+	userID, ok := tokenUserID(w, r)
+	if !ok {
+		db.Close()
+		return
+	}
 
-	if id == "" || token == "" {
+	if id == "" || userID == "" {
 		panic("id or token is nil")
 	}
 
 	tx := db.MustBegin()
-	tx.MustExec("delete from user_filter where user_id=$1 and module_id=$2", token, id)
+	tx.MustExec("delete from user_filter where user_id=$1 and module_id=$2", userID, id)
 	tx.Commit()
 	db.Close()
 	res := resResultT{
@@ -267,52 +404,8 @@ func ResetUserMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
 func OverrideUserMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
-	db, err := sqlx.Connect("sqlite3", "_db.db")
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	ids := r.URL.Query()["id"]
-	var id string
-	if len(ids) >= 1 {
-		id = ids[0]
-	}
-
-	if id == "" {
-		panic("id can not be null")
-	}
-
-	decoder := json.NewDecoder(r.Body)
-	filter := make(map[string][]string)
-	err = decoder.Decode(&filter)
-	if err != nil {
-		panic(err)
-	}
 
-	allUserFilterFields := sliceString{
+	overrideUserLayout(w, r, "user_filter", "filter", sliceString{
 		"hidden",
-	}
-
-	tx := db.MustBegin()
-	for k, v := range filter {
-		sqlStr := "update user_filter set "
-		for _, filed := range v {
-			if allUserFilterFields.search(filed) {
-				sqlStr = sqlStr + filed + "=null, "
-			}
-		}
-		if strings.HasSuffix(sqlStr, ", ") {
-			newSQLStr := strings.TrimSuffix(sqlStr, ", ")
-			newSQLStr += " where value=$1 and module_id=$2"
-			tx.MustExec(newSQLStr, k, id)
-		}
-	}
-	tx.Commit()
-
-	db.Close()
-	res := resResultT{
-		Code: "0",
-		Des:  "",
-	}
-	json.NewEncoder(w).Encode(res)
+	})
 }
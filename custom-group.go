@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 多级表头: raw_column.parent 指向同一模块中作为分组的列的 value, 顶层为 ''
+
+// checkColumnGroups 分组必须存在于同一模块且不能成环
+func checkColumnGroups(tx *sqlx.Tx, id string) error {
+	type parentT struct {
+		Value  string `db:"value"`
+		Parent string `db:"parent"`
+	}
+
+	rows := []parentT{}
+	err := tx.Select(&rows, "select value, coalesce(parent, '') as parent from raw_column where module_id=$1", id)
+	if err != nil {
+		panic(err)
+	}
+
+	parents := make(map[string]string)
+	for _, row := range rows {
+		if row.Value == "" {
+			return fmt.Errorf("列 value 不能为空")
+		}
+		parents[row.Value] = row.Parent
+	}
+
+	for value, parent := range parents {
+		for depth := 0; parent != ""; depth++ {
+			if _, ok := parents[parent]; !ok {
+				return fmt.Errorf("列 %s 的分组 %s 不存在", value, parent)
+			}
+			if parent == value || depth > len(parents) {
+				return fmt.Errorf("列 %s 的分组形成了循环", value)
+			}
+			parent = parents[parent]
+		}
+	}
+	return nil
+}
+
+// deletedParent 被删除分组的上一层, 子列会移到这里
+func deletedParent(deleted []map[string]interface{}) string {
+	if len(deleted) == 0 || deleted[0]["parent"] == nil {
+		return ""
+	}
+	return fmt.Sprint(deleted[0]["parent"])
+}
+
+// columnTree 把按 seq 排好序的平铺列表转成树, 同一分组内保持原有顺序.
+// 分组隐藏或固定时子列也隐藏或固定
+func columnTree(columns []userColumn) []userColumn {
+	values := make(map[string]bool)
+	for _, c := range columns {
+		values[c.Value] = true
+	}
+
+	children := make(map[string][]userColumn)
+	for _, c := range columns {
+		parent := c.Parent
+		if !values[parent] {
+			parent = ""
+		}
+		children[parent] = append(children[parent], c)
+	}
+
+	var build func(parent *userColumn) []userColumn
+	build = func(parent *userColumn) []userColumn {
+		value := ""
+		if parent != nil {
+			value = parent.Value
+		}
+		list := children[value]
+		for i := range list {
+			if parent != nil {
+				if parent.Hidden == "1" {
+					list[i].Hidden = "1"
+				}
+				if parent.Fixed == "1" {
+					list[i].Fixed = "1"
+				}
+				if parent.Frozen == "1" {
+					list[i].Frozen = "1"
+				}
+			}
+			// value 为空的列与顶层的 key 相同, 不能作为分组
+			if list[i].Value != "" {
+				list[i].Children = build(&list[i])
+			}
+		}
+		return list
+	}
+
+	tree := build(nil)
+	if tree == nil {
+		tree = []userColumn{}
+	}
+	return tree
+}
+
+// flattenColumns 深度优先展开树, 兼容直接提交平铺列表
+func flattenColumns(columns []userColumn) []userColumn {
+	flat := []userColumn{}
+	for _, c := range columns {
+		children := c.Children
+		c.Children = nil
+		flat = append(flat, c)
+		flat = append(flat, flattenColumns(children)...)
+	}
+	return flat
+}
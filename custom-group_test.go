@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestColumnTree(t *testing.T) {
+	columns := []userColumn{
+		{Value: "group", Hidden: "1"},
+		{Value: "a", Parent: "group"},
+		{Value: "b", Parent: "missing"},
+		{Value: "", Name: "空"},
+		{Value: "c", Parent: "group", Fixed: "1"},
+	}
+
+	tree := columnTree(columns)
+	if len(tree) != 3 {
+		t.Fatalf("top level = %d, want 3", len(tree))
+	}
+	if tree[0].Value != "group" || len(tree[0].Children) != 2 {
+		t.Fatalf("group children = %v", tree[0].Children)
+	}
+	for _, c := range tree[0].Children {
+		if c.Hidden != "1" {
+			t.Fatalf("column %s not hidden with its group", c.Value)
+		}
+	}
+	if tree[1].Value != "b" {
+		t.Fatalf("orphan column = %q, want b at top level", tree[1].Value)
+	}
+	if tree[2].Value != "" || len(tree[2].Children) != 0 {
+		t.Fatalf("empty value column = %+v, want a leaf", tree[2])
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var historyTables = map[string]string{
+	"table":  "raw_column",
+	"filter": "raw_filter",
+}
+
+// customizationTables 删除列或过滤时一起删除的角色和用户设置, 也记录在修改记录中, 回滚时一起恢复
+var customizationTables = map[string][]string{
+	"table":  {"role_column", "user_column"},
+	"filter": {"role_filter", "user_filter"},
+}
+
+type historyT struct {
+	ID       int      `db:"id" json:"id"`
+	MoudleID string   `db:"module_id" json:"moduleId"`
+	Target   string   `db:"target" json:"target"`
+	Revision int      `db:"revision" json:"revision"`
+	RowID    string   `db:"row_id" json:"rowId"`
+	Table    string   `db:"row_table" json:"table,omitempty"`
+	Action   string   `db:"action" json:"action"`
+	Actor    string   `db:"actor" json:"actor"`
+	Created  string   `db:"created_at" json:"createdAt"`
+	Before   jsonText `db:"before_value" json:"before"`
+	After    jsonText `db:"after_value" json:"after"`
+}
+
+// jsonText 数据库中以文本保存的 json, 输出时原样嵌入
+type jsonText []byte
+
+func (j *jsonText) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*j = jsonText("null")
+	case string:
+		*j = jsonText(src)
+	case []byte:
+		*j = append(jsonText{}, src...)
+	default:
+		return fmt.Errorf("unsupported json text %T", src)
+	}
+	return nil
+}
+
+func (j jsonText) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+// requestActor 操作人, token 无效时为空
+func requestActor(r *http.Request) string {
+	claims, err := parseToken(r.Header.Get("token"))
+	if err != nil {
+		return ""
+	}
+	return claims.UserID
+}
+
+// snapshotRows 读取整行数据, 用于记录修改前后的值
+func snapshotRows(tx *sqlx.Tx, table, where string, args ...interface{}) []map[string]interface{} {
+	rows, err := tx.Queryx("select * from "+table+" where "+where, args...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	result := []map[string]interface{}{}
+	for rows.Next() {
+		row := make(map[string]interface{})
+		err = rows.MapScan(row)
+		if err != nil {
+			panic(err)
+		}
+		for k, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[k] = string(b)
+			}
+		}
+		result = append(result, row)
+	}
+	return result
+}
+
+func snapshotRow(tx *sqlx.Tx, table, id string) map[string]interface{} {
+	rows := snapshotRows(tx, table, "id=$1", id)
+	if len(rows) == 0 {
+		return nil
+	}
+	return rows[0]
+}
+
+func recordHistory(tx *sqlx.Tx, moduleID, target string, revision int, actor, action, rowID string, before, after map[string]interface{}) {
+	recordTableHistory(tx, moduleID, target, "", revision, actor, action, rowID, before, after)
+}
+
+// recordTableHistory 记录 target 之外的表中的行, table 为空时为 target 对应的表
+func recordTableHistory(tx *sqlx.Tx, moduleID, target, table string, revision int, actor, action, rowID string, before, after map[string]interface{}) {
+	var beforeValue, afterValue interface{}
+	if before != nil {
+		b, err := json.Marshal(before)
+		if err != nil {
+			panic(err)
+		}
+		beforeValue = string(b)
+	}
+	if after != nil {
+		b, err := json.Marshal(after)
+		if err != nil {
+			panic(err)
+		}
+		afterValue = string(b)
+	}
+
+	var rowTable interface{}
+	if table != "" {
+		rowTable = table
+	}
+
+	tx.MustExec("insert into module_history(module_id, target, revision, row_id, action, actor, created_at, before_value, after_value, row_table) values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)",
+		moduleID, target, revision, rowID, action, actor, time.Now().Format("2006-01-02 15:04:05"), beforeValue, afterValue, rowTable)
+}
+
+// deleteCustomizations 删除模块中 value 的角色和用户设置并记录, 返回删除的用户设置行数
+func deleteCustomizations(tx *sqlx.Tx, moduleID, target string, revision int, actor, value string) int64 {
+	var userRows int64
+	for _, table := range customizationTables[target] {
+		for _, before := range snapshotRows(tx, table, "value=$1 and module_id=$2", value, moduleID) {
+			rowID := fmt.Sprint(before["id"])
+			tx.MustExec("delete from "+table+" where id=$1", rowID)
+			recordTableHistory(tx, moduleID, target, table, revision, actor, "delete", rowID, before, nil)
+			if strings.HasPrefix(table, "user_") {
+				userRows++
+			}
+		}
+	}
+	return userRows
+}
+
+// restoreRow 按快照恢复整行
+func restoreRow(tx *sqlx.Tx, table string, row map[string]interface{}) {
+	fields := []string{}
+	for field := range row {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	placeholders := []string{}
+	args := []interface{}{}
+	for i, field := range fields {
+		placeholders = append(placeholders, "$"+strconv.Itoa(i+1))
+		args = append(args, row[field])
+	}
+
+	tx.MustExec("insert or replace into "+table+"("+strings.Join(fields, ", ")+") values("+strings.Join(placeholders, ", ")+")", args...)
+}
+
+// GetMaintenanceHistory 模块修改记录
+func GetMaintenanceHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	targets := r.URL.Query()["target"]
+	target := ""
+	if len(targets) >= 1 {
+		target = targets[0]
+	}
+
+	if id == "" {
+		panic("id can not be null")
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	history := []historyT{}
+	err = db.Select(&history, `
+						select id, module_id, target, revision, row_id, action, actor, created_at,
+						before_value, after_value, coalesce(row_table, '') as row_table
+						from module_history
+						where module_id=$1 and ($2='' or target=$2)
+						order by id desc
+						`, id, target)
+	if err != nil {
+		panic(err)
+	}
+
+	db.Close()
+
+	res := resResultT{
+		Code:   "0",
+		Des:    "",
+		Result: history,
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// RollbackMaintenance 把模块恢复到指定版本, If-Match 或 revision 为当前版本号
+func RollbackMaintenance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	targets := r.URL.Query()["target"]
+	target := ""
+	if len(targets) >= 1 {
+		target = targets[0]
+	}
+
+	table, ok := historyTables[target]
+	if id == "" || !ok {
+		panic("id or target is invalid")
+	}
+
+	tos := r.URL.Query()["to"]
+	to := -1
+	if len(tos) >= 1 {
+		to, _ = strconv.Atoi(tos[0])
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	expected, ok := requestRevision(r)
+	if !ok {
+		db.Close()
+		writeRevisionRequired(w)
+		return
+	}
+
+	revision := moduleRevision(db, id, target)
+	if expected != revision {
+		current := moduleDefinitions(db, id, target)
+		db.Close()
+		writeRevisionConflict(w, revision, current)
+		return
+	}
+	if to < 0 || to >= revision {
+		db.Close()
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "1",
+			Des:  "版本号无效",
+		})
+		return
+	}
+
+	tx := db.MustBegin()
+
+	history := []historyT{}
+	err = tx.Select(&history, `
+						select id, row_id, action, before_value, coalesce(row_table, '') as row_table
+						from module_history
+						where module_id=$1 and target=$2 and revision>$3
+						order by id desc
+						`, id, target, to)
+	if err != nil {
+		panic(err)
+	}
+
+	actor := requestActor(r)
+	newRevision := revision + 1
+	if !bumpModuleRevision(tx, id, target, revision) {
+		tx.Rollback()
+		current := moduleDefinitions(db, id, target)
+		currentRevision := moduleRevision(db, id, target)
+		db.Close()
+		writeRevisionConflict(w, currentRevision, current)
+		return
+	}
+
+	// 倒序撤销 to 之后的每一次修改, 包括删除时一起删除的角色和用户设置, 撤销本身也记录下来
+	for _, h := range history {
+		rowTable := table
+		if h.Table != "" {
+			rowTable = h.Table
+		}
+		current := snapshotRow(tx, rowTable, h.RowID)
+
+		if h.Action == "insert" {
+			if current != nil {
+				tx.MustExec("delete from "+rowTable+" where id=$1", h.RowID)
+				recordTableHistory(tx, id, target, h.Table, newRevision, actor, "delete", h.RowID, current, nil)
+			}
+			continue
+		}
+
+		before := make(map[string]interface{})
+		decoder := json.NewDecoder(bytes.NewReader(h.Before))
+		decoder.UseNumber()
+		err = decoder.Decode(&before)
+		if err != nil {
+			panic(err)
+		}
+		restoreRow(tx, rowTable, before)
+		if current == nil {
+			recordTableHistory(tx, id, target, h.Table, newRevision, actor, "insert", h.RowID, nil, before)
+		} else {
+			recordTableHistory(tx, id, target, h.Table, newRevision, actor, "update", h.RowID, current, before)
+		}
+	}
+
+	tx.Commit()
+	db.Close()
+
+	setRevisionHeader(w, newRevision)
+	res := revisionResultT{
+		Code:     "0",
+		Des:      "",
+		Revision: newRevision,
+	}
+	json.NewEncoder(w).Encode(res)
+}
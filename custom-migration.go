@@ -0,0 +1,222 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 启动时执行的数据迁移, 执行过的记录在 schema_migration 中
+
+var migrations = []struct {
+	Name string
+	Run  func(tx *sqlx.Tx)
+}{
+	{"add-layout-columns", addLayoutColumns},
+	{"rekey-user-layout-by-user-id", rekeyUserLayouts},
+	{"seed-mock-user", seedMockUser},
+	{"seed-role-permission", seedRolePermission},
+	{"seed-policy-users", seedPolicyUsers},
+	{"seed-action-permission", seedActionPermission},
+	{"seed-directory", seedDirectory},
+	{"index-person-fts", indexPersonFTS},
+	{"drop-user-column-defaults", dropUserColumnDefaults},
+	{"split-login-attempts", splitLoginAttempts},
+	{"add-history-row-table", addHistoryRowTable},
+	{"dedupe-user-layouts", dedupeUserLayouts},
+}
+
+// runMigrations 对指定的数据库文件执行迁移
+func runMigrations(file string) {
+	db, err := sqlx.Connect("sqlite3", file)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db.MustExec(schema)
+
+	for _, m := range migrations {
+		var count int
+		err = db.Get(&count, "select count(*) from schema_migration where name=$1", m.Name)
+		if err != nil {
+			panic(err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		tx := db.MustBegin()
+		m.Run(tx)
+		tx.MustExec("insert into schema_migration(name, applied_at) values($1, $2)", m.Name, time.Now().Format("2006-01-02 15:04:05"))
+		tx.Commit()
+		log.Printf("migration %s applied to %s\n", m.Name, file)
+	}
+
+	db.Close()
+}
+
+// layoutColumns 布局表在基础表结构之后新增的字段
+var layoutColumns = []struct {
+	Table  string
+	Column string
+	Type   string
+}{
+	{"raw_column", "parent", "VARCHAR (255) DEFAULT ('')"},
+	{"raw_column", "min_width", "INTEGER DEFAULT (0)"},
+	{"raw_column", "max_width", "INTEGER DEFAULT (0)"},
+	{"raw_filter", "type", "VARCHAR (32) DEFAULT ('text')"},
+	{"raw_filter", "operators", "VARCHAR (255) DEFAULT ('')"},
+	{"raw_filter", "default_value", "VARCHAR (255) DEFAULT ('')"},
+	{"raw_filter", "options_source", "VARCHAR (255) DEFAULT ('')"},
+	{"user_column", "updated_at", "VARCHAR (20)"},
+	{"user_filter", "updated_at", "VARCHAR (20)"},
+}
+
+func tableExists(tx *sqlx.Tx, table string) bool {
+	var count int
+	err := tx.Get(&count, "select count(*) from sqlite_master where type='table' and name=$1", table)
+	if err != nil {
+		panic(err)
+	}
+	return count > 0
+}
+
+// addLayoutColumns 给已有数据库补上分组, 列宽范围, 过滤类型和修改时间字段
+func addLayoutColumns(tx *sqlx.Tx) {
+	for _, c := range layoutColumns {
+		if tableExists(tx, c.Table) && !columnExists(tx, c.Table, c.Column) {
+			tx.MustExec("alter table " + c.Table + " add column " + c.Column + " " + c.Type)
+		}
+	}
+}
+
+// rekeyUserLayouts 旧数据以整个 token 作为 user_id, 改成 token 中的 userId.
+// 同一用户的多个 token 在同一列上都有设置时, 保留 iat 最新的一条; 已经有按 userId 保存的设置时删除旧数据
+func rekeyUserLayouts(tx *sqlx.Tx) {
+	type layoutRowT struct {
+		ID       string `db:"id"`
+		UserID   string `db:"user_id"`
+		MoudleID string `db:"module_id"`
+		Value    string `db:"value"`
+	}
+
+	for _, table := range []string{"user_column", "user_filter"} {
+		rows := []layoutRowT{}
+		err := tx.Select(&rows, "select id, user_id, coalesce(module_id, '') as module_id, coalesce(value, '') as value from "+table)
+		if err != nil {
+			panic(err)
+		}
+
+		type keptT struct {
+			ID  string
+			Iat int64
+		}
+		current := make(map[string]bool)
+		for _, row := range rows {
+			claims, err := decodeTokenClaims(row.UserID)
+			if err != nil || claims.UserID == "" || claims.UserID == row.UserID {
+				current[row.UserID+"|"+row.MoudleID+"|"+row.Value] = true
+			}
+		}
+
+		kept := make(map[string]keptT)
+		rekeyed := make(map[string]string)
+		for _, row := range rows {
+			claims, err := decodeTokenClaims(row.UserID)
+			if err != nil || claims.UserID == "" || claims.UserID == row.UserID {
+				continue
+			}
+			rekeyed[row.ID] = claims.UserID
+
+			key := claims.UserID + "|" + row.MoudleID + "|" + row.Value
+			if current[key] {
+				tx.MustExec("delete from "+table+" where id=$1", row.ID)
+				continue
+			}
+			if k, ok := kept[key]; ok && k.Iat >= claims.Iat {
+				tx.MustExec("delete from "+table+" where id=$1", row.ID)
+				continue
+			} else if ok {
+				tx.MustExec("delete from "+table+" where id=$1", k.ID)
+			}
+			kept[key] = keptT{ID: row.ID, Iat: claims.Iat}
+		}
+
+		for _, k := range kept {
+			tx.MustExec("update "+table+" set user_id=$1 where id=$2", rekeyed[k.ID], k.ID)
+		}
+		log.Printf("%s: %d rows rekeyed, %d duplicates removed\n", table, len(kept), len(rekeyed)-len(kept))
+	}
+}
+
+// dedupeUserLayouts 早期的 rekeyUserLayouts 可能留下同一用户在同一列上的多条设置, 保留最后修改的一条
+func dedupeUserLayouts(tx *sqlx.Tx) {
+	for _, table := range []string{"user_column", "user_filter"} {
+		if !tableExists(tx, table) {
+			continue
+		}
+		result := tx.MustExec(`
+			delete from ` + table + ` where rowid not in (
+				select rowid from (
+					select rowid, row_number() over (
+						partition by user_id, module_id, value
+						order by coalesce(updated_at, '') desc, rowid desc
+					) as n from ` + table + `
+				) where n = 1
+			)`)
+		n, err := result.RowsAffected()
+		if err != nil {
+			panic(err)
+		}
+		log.Printf("%s: %d duplicate rows removed\n", table, n)
+	}
+}
+
+// dropUserColumnDefaults 去掉 user_column 上 hidden, frozen, location, width 的默认值,
+// 没有设置的字段为 NULL, 合并时回退到角色和模块的设置.
+// 只保存了列宽的行 (seq 为空) 上的默认值也一并清掉
+func dropUserColumnDefaults(tx *sqlx.Tx) {
+	if !tableExists(tx, "user_column") {
+		return
+	}
+
+	tx.MustExec(`
+		CREATE TABLE user_column_new (
+			module_id VARCHAR (255) DEFAULT (''),
+			user_id VARCHAR (255) DEFAULT (''),
+			value VARCHAR (255) DEFAULT (''),
+			hidden CHAR (1),
+			frozen CHAR (1),
+			seq INTEGER (9),
+			location CHAR (2),
+			width INTEGER,
+			id VARCHAR (255) PRIMARY KEY DEFAULT (''),
+			rule VARCHAR (255),
+			updated_at VARCHAR (20)
+		)`)
+	tx.MustExec(`
+		insert into user_column_new(module_id, user_id, value, hidden, frozen, seq, location, width, id, rule, updated_at)
+		select module_id, user_id, value, hidden, frozen, seq, location, nullif(width, ''), id, rule, updated_at from user_column`)
+	tx.MustExec("drop table user_column")
+	tx.MustExec("alter table user_column_new rename to user_column")
+
+	result := tx.MustExec("update user_column set hidden=null, frozen=null, location=null where seq is null")
+	n, err := result.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+	log.Printf("user_column: defaults dropped, %d width-only rows cleared\n", n)
+}
+
+// splitLoginAttempts 旧的按 用户名 + IP 的计数不再使用
+func splitLoginAttempts(tx *sqlx.Tx) {
+	tx.MustExec("delete from login_attempt where username!='' and ip!=''")
+}
+
+// addHistoryRowTable 修改记录中保存行所在的表, 为空时为 target 对应的 raw_column 或 raw_filter
+func addHistoryRowTable(tx *sqlx.Tx) {
+	if !columnExists(tx, "module_history", "row_table") {
+		tx.MustExec("alter table module_history add column row_table VARCHAR(40)")
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 覆盖用户设置: 把指定列上的用户字段置空, 回退到角色和模块的设置.
+// 范围通过参数限定, 都不传时作用于模块的所有用户:
+//   users  逗号分隔的用户, 可以重复传
+//   role   拥有该角色的用户
+//   since  在该时间之后修改过设置的用户, 格式 2006-01-02 或 2006-01-02 15:04:05
+//   dryRun 为 1 时只返回影响范围
+// 每次执行都记录到 override_audit
+
+type overrideAuditT struct {
+	ID       int      `db:"id" json:"id"`
+	MoudleID string   `db:"module_id" json:"moduleId"`
+	Target   string   `db:"target" json:"target"`
+	Actor    string   `db:"actor" json:"actor"`
+	Scope    jsonText `db:"scope" json:"scope"`
+	Fields   jsonText `db:"fields" json:"fields"`
+	DryRun   string   `db:"dry_run" json:"dryRun"`
+	Users    int      `db:"users" json:"users"`
+	Rows     int      `db:"rows" json:"rows"`
+	Created  string   `db:"created_at" json:"createdAt"`
+}
+
+type overrideScopeT struct {
+	Users []string `json:"users,omitempty"`
+	Role  string   `json:"role,omitempty"`
+	Since string   `json:"since,omitempty"`
+}
+
+type overrideRowT struct {
+	UserID string   `json:"userId"`
+	Value  string   `json:"value"`
+	Fields []string `json:"fields"`
+}
+
+type overrideResultT struct {
+	DryRun bool           `json:"dryRun"`
+	Users  []string       `json:"users"`
+	Rows   []overrideRowT `json:"rows"`
+}
+
+func requestOverrideScope(r *http.Request) (overrideScopeT, error) {
+	scope := overrideScopeT{}
+	query := r.URL.Query()
+
+	for _, users := range query["users"] {
+		for _, user := range strings.Split(users, ",") {
+			if user = strings.TrimSpace(user); user != "" {
+				scope.Users = append(scope.Users, user)
+			}
+		}
+	}
+	scope.Role = query.Get("role")
+	scope.Since = query.Get("since")
+
+	if scope.Since != "" {
+		_, err := time.Parse("2006-01-02", scope.Since)
+		if err != nil {
+			_, err = time.Parse("2006-01-02 15:04:05", scope.Since)
+		}
+		if err != nil {
+			return scope, fmt.Errorf("since %q 格式错误", scope.Since)
+		}
+	}
+	return scope, nil
+}
+
+// where 范围对应的查询条件, 参数从 $3 开始 ($1 value, $2 module_id)
+func (scope overrideScopeT) where() (string, []interface{}) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if len(scope.Users) > 0 {
+		placeholders := []string{}
+		for _, user := range scope.Users {
+			args = append(args, user)
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)+2))
+		}
+		conditions = append(conditions, "user_id in ("+strings.Join(placeholders, ", ")+")")
+	}
+	if scope.Role != "" {
+		args = append(args, scope.Role)
+		conditions = append(conditions, fmt.Sprintf("user_id in (select user_id from user_role where role=$%d)", len(args)+2))
+	}
+	if scope.Since != "" {
+		args = append(args, scope.Since)
+		conditions = append(conditions, fmt.Sprintf("updated_at >= $%d", len(args)+2))
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " and " + strings.Join(conditions, " and "), args
+}
+
+// overrideUserLayout OverrideUserMaintenanceTable 和 OverrideUserMaintenanceFilter 的共同实现
+func overrideUserLayout(w http.ResponseWriter, r *http.Request, table, target string, allowedFields sliceString) {
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	if id == "" {
+		panic("id can not be null")
+	}
+
+	scope, err := requestOverrideScope(r)
+	if err != nil {
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "1",
+			Des:  err.Error(),
+		})
+		return
+	}
+	dryRun := r.URL.Query().Get("dryRun") == "1" || r.URL.Query().Get("dryRun") == "true"
+
+	decoder := json.NewDecoder(r.Body)
+	columns := make(map[string][]string)
+	err = decoder.Decode(&columns)
+	if err != nil {
+		panic(err)
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	values := []string{}
+	for value := range columns {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	result := overrideResultT{
+		DryRun: dryRun,
+		Users:  []string{},
+		Rows:   []overrideRowT{},
+	}
+	users := make(map[string]bool)
+	where, scopeArgs := scope.where()
+
+	tx := db.MustBegin()
+	for _, value := range values {
+		fields := []string{}
+		for _, field := range columns[value] {
+			if allowedFields.search(field) {
+				fields = append(fields, field)
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		// 只有字段不全为空的行才算受影响
+		notNull := []string{}
+		for _, field := range fields {
+			notNull = append(notNull, field+" is not null")
+		}
+		condition := " where value=$1 and module_id=$2 and (" + strings.Join(notNull, " or ") + ")" + where
+		args := append([]interface{}{value, id}, scopeArgs...)
+
+		affected := []string{}
+		err = tx.Select(&affected, "select user_id from "+table+condition+" order by user_id", args...)
+		if err != nil {
+			panic(err)
+		}
+		for _, user := range affected {
+			result.Rows = append(result.Rows, overrideRowT{
+				UserID: user,
+				Value:  value,
+				Fields: fields,
+			})
+			if !users[user] {
+				users[user] = true
+				result.Users = append(result.Users, user)
+			}
+		}
+
+		if !dryRun && len(affected) > 0 {
+			sets := []string{}
+			for _, field := range fields {
+				sets = append(sets, field+"=null")
+			}
+			tx.MustExec("update "+table+" set "+strings.Join(sets, ", ")+condition, args...)
+		}
+	}
+	sort.Strings(result.Users)
+
+	scopeJSON, err := json.Marshal(scope)
+	if err != nil {
+		panic(err)
+	}
+	fieldsJSON, err := json.Marshal(columns)
+	if err != nil {
+		panic(err)
+	}
+	dryRunFlag := "0"
+	if dryRun {
+		dryRunFlag = "1"
+	}
+	tx.MustExec("insert into override_audit(module_id, target, actor, scope, fields, dry_run, users, rows, created_at) values($1,$2,$3,$4,$5,$6,$7,$8,$9)",
+		id, target, requestActor(r), string(scopeJSON), string(fieldsJSON), dryRunFlag, len(result.Users), len(result.Rows), time.Now().Format("2006-01-02 15:04:05"))
+
+	tx.Commit()
+	db.Close()
+
+	res := resResultT{
+		Code:   "0",
+		Des:    "",
+		Result: result,
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// GetOverrideAudit 覆盖用户设置的记录
+func GetOverrideAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	audits := []overrideAuditT{}
+	err = db.Select(&audits, `
+						select id, module_id, target, actor, scope, fields, dry_run, users, rows, created_at
+						from override_audit
+						where $1='' or module_id=$1
+						order by id desc
+						`, id)
+	if err != nil {
+		panic(err)
+	}
+
+	db.Close()
+
+	res := resResultT{
+		Code:   "0",
+		Des:    "",
+		Result: audits,
+	}
+	json.NewEncoder(w).Encode(res)
+}
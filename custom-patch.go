@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// columnOpT 对单列的增量修改
+// op: hide, show, freeze, unfreeze, move-before, move-after, width, rule
+type columnOpT struct {
+	Op     string `json:"op"`
+	Value  string `json:"value"`
+	Target string `json:"target"`
+	Width  string `json:"width"`
+	Rule   string `json:"rule"`
+}
+
+type columnOpErrorT struct {
+	Index int    `json:"index"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+	Error string `json:"error"`
+}
+
+// applyColumnOps 在合并后的布局上依次执行修改, 返回每列被修改的字段; 有任何一步失败时不返回修改.
+// 移动后重写的 seq 是列在按分组展开后的整个列表中的位置, 与 raw_column.seq 一致
+func applyColumnOps(columns []userColumn, ops []columnOpT) (map[string]map[string]interface{}, []columnOpErrorT) {
+	byValue := make(map[string]*userColumn)
+	for i := range columns {
+		byValue[columns[i].Value] = &columns[i]
+	}
+	// 分组不存在的列和 columnTree 一样放在顶层
+	groupOf := func(c *userColumn) string {
+		if _, ok := byValue[c.Parent]; ok {
+			return c.Parent
+		}
+		return ""
+	}
+	groups := make(map[string][]string)
+	for i := range columns {
+		c := &columns[i]
+		groups[groupOf(c)] = append(groups[groupOf(c)], c.Value)
+	}
+	moved := false
+
+	dirty := make(map[string]map[string]interface{})
+	set := func(c *userColumn, field string, v interface{}) {
+		if dirty[c.Value] == nil {
+			dirty[c.Value] = make(map[string]interface{})
+		}
+		dirty[c.Value][field] = v
+	}
+	errors := []columnOpErrorT{}
+	fail := func(i int, op columnOpT, format string, args ...interface{}) {
+		errors = append(errors, columnOpErrorT{
+			Index: i,
+			Op:    op.Op,
+			Value: op.Value,
+			Error: fmt.Sprintf(format, args...),
+		})
+	}
+
+	for i, op := range ops {
+		c, ok := byValue[op.Value]
+		if !ok {
+			fail(i, op, "列 %s 不存在", op.Value)
+			continue
+		}
+
+		switch op.Op {
+		case "hide":
+			set(c, "hidden", "1")
+		case "show":
+			set(c, "hidden", "0")
+		case "freeze":
+			set(c, "frozen", "1")
+		case "unfreeze":
+			set(c, "frozen", "0")
+		case "width":
+			width, err := strconv.Atoi(op.Width)
+			if err != nil || width <= 0 {
+				fail(i, op, "宽度 %q 应为正整数", op.Width)
+				continue
+			}
+			set(c, "width", clampWidth(width, c.MinWidth, c.MaxWidth))
+		case "rule":
+			if _, err := parseRule(op.Rule); err != nil {
+				fail(i, op, "%s", err.Error())
+				continue
+			}
+			set(c, "rule", op.Rule)
+		case "move-before", "move-after":
+			target, ok := byValue[op.Target]
+			if !ok {
+				fail(i, op, "目标列 %s 不存在", op.Target)
+				continue
+			}
+			if groupOf(target) != groupOf(c) {
+				fail(i, op, "只能在同一分组内移动")
+				continue
+			}
+			if target.Value == c.Value {
+				continue
+			}
+
+			siblings := []string{}
+			for _, v := range groups[groupOf(c)] {
+				if v != c.Value {
+					siblings = append(siblings, v)
+				}
+			}
+			order := []string{}
+			for _, v := range siblings {
+				if v == target.Value && op.Op == "move-before" {
+					order = append(order, c.Value)
+				}
+				order = append(order, v)
+				if v == target.Value && op.Op == "move-after" {
+					order = append(order, c.Value)
+				}
+			}
+			groups[groupOf(c)] = order
+			moved = true
+		default:
+			fail(i, op, "未知的操作 %s", op.Op)
+		}
+	}
+
+	if len(errors) > 0 {
+		return nil, errors
+	}
+	if !moved {
+		return dirty, nil
+	}
+
+	seqs := make(map[string]int)
+	var walk func(parent string)
+	walk = func(parent string) {
+		for _, v := range groups[parent] {
+			seqs[v] = len(seqs)
+			walk(v)
+		}
+	}
+	walk("")
+
+	// 顺序变化后位置改变的列 (包括分组下的子列) 都要重写 seq
+	for v, c := range byValue {
+		if strconv.Itoa(seqs[v]) != c.Seq {
+			set(c, "seq", seqs[v])
+		}
+	}
+	return dirty, nil
+}
+
+func patchUserMaintenanceTable(w http.ResponseWriter, r *http.Request) {
+	ops := []columnOpT{}
+	err := json.NewDecoder(r.Body).Decode(&ops)
+	if err != nil {
+		panic(err)
+	}
+
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	userID, ok := tokenUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if id == "" {
+		panic("id can not be null")
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	role := layoutRole(db, requestRoles(db, userID), "role_column", id)
+	dirty, errors := applyColumnOps(userMaintenanceColumns(db, userID, role, id), ops)
+	if len(errors) > 0 {
+		db.Close()
+		json.NewEncoder(w).Encode(resResultT{
+			Code:   "1",
+			Des:    "操作校验失败",
+			Result: errors,
+		})
+		return
+	}
+
+	// 只写入被修改的字段, 其余字段继续使用角色和模块的设置
+	now := time.Now().Format("2006-01-02 15:04:05")
+	tx := db.MustBegin()
+	for value, fields := range dirty {
+		fields["updated_at"] = now
+		upsertLayoutColumn(tx, "user_column", "user_id", userID, id, value, fields)
+	}
+	tx.Commit()
+
+	columns := columnTree(userMaintenanceColumns(db, userID, role, id))
+
+	db.Close()
+
+	res := resResultT{
+		Code:   "0",
+		Des:    "",
+		Result: columns,
+	}
+	json.NewEncoder(w).Encode(res)
+}
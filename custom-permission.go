@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 菜单节点可以设置 roles (任一角色可见) 或 code (角色在 role_permission 中有该权限码时可见),
+// 都没有设置时所有人可见
+
+type rolePermissionT struct {
+	Role  string   `json:"role"`
+	Codes []string `json:"codes"`
+}
+
+func readMenus() []permissionT {
+	plan, err := ioutil.ReadFile("ds.json")
+	if err != nil {
+		panic(err)
+	}
+
+	menus := []permissionT{}
+	err = json.Unmarshal(plan, &menus)
+	if err != nil {
+		panic(err)
+	}
+	return menus
+}
+
+// roleCodes 角色拥有的权限码
+func roleCodes(db *sqlx.DB, roles []string) sliceString {
+	codes := sliceString{}
+	for _, role := range roles {
+		roleCodes := []string{}
+		err := db.Select(&roleCodes, "select code from role_permission where role=$1", role)
+		if err != nil {
+			panic(err)
+		}
+		codes = append(codes, roleCodes...)
+	}
+	return codes
+}
+
+func menuVisible(menu permissionT, roles, codes sliceString) bool {
+	if len(menu.Roles) == 0 && menu.Code == "" {
+		return true
+	}
+	for _, role := range menu.Roles {
+		if roles.search(role) {
+			return true
+		}
+	}
+	return menu.Code != "" && codes.search(menu.Code)
+}
+
+// pruneMenus 去掉不可见的节点, 子菜单全部不可见的父节点也去掉. 叶子节点的 subMenu 为 []
+func pruneMenus(menus []permissionT, roles, codes sliceString) []permissionT {
+	result := []permissionT{}
+	for _, menu := range menus {
+		if !menuVisible(menu, roles, codes) {
+			continue
+		}
+		if len(menu.SubMenu) > 0 {
+			menu.SubMenu = pruneMenus(menu.SubMenu, roles, codes)
+			if len(menu.SubMenu) == 0 {
+				continue
+			}
+		} else {
+			menu.SubMenu = []permissionT{}
+		}
+		result = append(result, menu)
+	}
+	return result
+}
+
+// checkMenus 菜单名称不能为空, 叶子节点必须有 path
+func checkMenus(menus []permissionT, prefix string) []string {
+	errors := []string{}
+	for i, menu := range menus {
+		name := fmt.Sprintf("%s%d", prefix, i)
+		if menu.Name == "" {
+			errors = append(errors, fmt.Sprintf("菜单 %s 名称不能为空", name))
+		}
+		if len(menu.SubMenu) == 0 && menu.Path == "" {
+			errors = append(errors, fmt.Sprintf("菜单 %s 没有子菜单时 path 不能为空", name))
+		}
+		errors = append(errors, checkMenus(menu.SubMenu, name+".")...)
+	}
+	return errors
+}
+
+func menuCodes(menus []permissionT, codes map[string]bool) {
+	for _, menu := range menus {
+		if menu.Code != "" {
+			codes[menu.Code] = true
+		}
+		menuCodes(menu.SubMenu, codes)
+	}
+}
+
+// PermissionMenu 完整的菜单树, POST 时整体替换 ds.json
+func PermissionMenu(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(resResultT{
+			Code:   "0",
+			Des:    "",
+			Result: readMenus(),
+		})
+	case http.MethodPost:
+		menus := []permissionT{}
+		err := json.NewDecoder(r.Body).Decode(&menus)
+		if err != nil {
+			panic(err)
+		}
+
+		if errors := checkMenus(menus, ""); len(errors) > 0 {
+			json.NewEncoder(w).Encode(resResultT{
+				Code:   "1",
+				Des:    "菜单校验失败",
+				Result: errors,
+			})
+			return
+		}
+
+		b, err := json.MarshalIndent(menus, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		err = ioutil.WriteFile("ds.json", append(b, '\n'), 0644)
+		if err != nil {
+			panic(err)
+		}
+
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "0",
+			Des:  "",
+		})
+	}
+}
+
+// PermissionRole 角色和权限码的对应关系, POST 时替换该角色的全部权限码
+func PermissionRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows := []struct {
+			Role string `db:"role"`
+			Code string `db:"code"`
+		}{}
+		err = db.Select(&rows, "select role, code from role_permission where $1='' or role=$1 order by role, code", r.URL.Query().Get("role"))
+		if err != nil {
+			panic(err)
+		}
+
+		result := []rolePermissionT{}
+		for _, row := range rows {
+			if len(result) == 0 || result[len(result)-1].Role != row.Role {
+				result = append(result, rolePermissionT{Role: row.Role, Codes: []string{}})
+			}
+			last := &result[len(result)-1]
+			last.Codes = append(last.Codes, row.Code)
+		}
+
+		json.NewEncoder(w).Encode(resResultT{
+			Code:   "0",
+			Des:    "",
+			Result: result,
+		})
+	case http.MethodPost:
+		param := rolePermissionT{}
+		err = json.NewDecoder(r.Body).Decode(&param)
+		if err != nil {
+			panic(err)
+		}
+
+		if param.Role == "" {
+			panic("role can not be null")
+		}
+
+		// 只允许菜单和按钮规则中出现过的权限码
+		known := make(map[string]bool)
+		menuCodes(readMenus(), known)
+		actionCodes(known)
+		errors := roleErrors(unknownRoles(db, []string{param.Role}))
+		for _, code := range param.Codes {
+			if !known[code] {
+				errors = append(errors, fmt.Sprintf("权限码 %s 不存在", code))
+			}
+		}
+		if len(errors) > 0 {
+			sort.Strings(errors)
+			json.NewEncoder(w).Encode(resResultT{
+				Code:   "1",
+				Des:    "权限码校验失败",
+				Result: errors,
+			})
+			break
+		}
+
+		tx := db.MustBegin()
+		tx.MustExec("delete from role_permission where role=$1", param.Role)
+		for _, code := range param.Codes {
+			tx.MustExec("insert or ignore into role_permission(role, code) values($1, $2)", param.Role, code)
+		}
+		tx.Commit()
+
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "0",
+			Des:  "",
+		})
+	}
+
+	db.Close()
+}
+
+// seedRolePermission admin 拥有菜单中的全部权限码
+func seedRolePermission(tx *sqlx.Tx) {
+	codes := make(map[string]bool)
+	menuCodes(readMenus(), codes)
+	for code := range codes {
+		tx.MustExec("insert or ignore into role_permission(role, code) values($1, $2)", "admin", code)
+	}
+}
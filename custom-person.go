@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/xid"
+)
+
+// person 的过滤, 排序和全文搜索. 参数名使用 Person 的 json 字段名
+
+// 可以过滤和排序的列, json 字段名 -> 列名
+var personColumns = map[string]string{
+	"id":        "id",
+	"firstName": "first_name",
+	"lastName":  "last_name",
+	"email":     "email",
+	"gender":    "gender",
+	"IPaddr":    "ip_address",
+	"city":      "city",
+	"country":   "country",
+	"latitude":  "latitude",
+	"longitude": "longitude",
+	"guid":      "guid",
+}
+
+// 按数字比较的列
+var personNumberColumns = sliceString{"id", "latitude", "longitude"}
+
+// personQuery 由请求参数生成 where 和 order by
+type personQuery struct {
+	Where []string
+	Args  []interface{}
+	Order []string
+}
+
+func (q *personQuery) arg(v interface{}) string {
+	q.Args = append(q.Args, v)
+	return "$" + strconv.Itoa(len(q.Args))
+}
+
+// ftsMatch 每个词按前缀匹配, 词之间为 and
+func ftsMatch(keyword string) string {
+	terms := []string{}
+	for _, term := range strings.Fields(keyword) {
+		term = strings.Replace(term, `"`, "", -1)
+		if term != "" {
+			terms = append(terms, `"`+term+`*"`)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+func (q *personQuery) filter(field string, v searchValueT) error {
+	column := personColumns[field]
+	expr := column
+	var value interface{} = v.Value
+	if personNumberColumns.search(column) {
+		expr = "cast(" + column + " as real)"
+	}
+	number := func(s string) (interface{}, error) {
+		if !personNumberColumns.search(column) {
+			return s, nil
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s 的值 %s 不是数字", field, s)
+		}
+		return f, nil
+	}
+
+	op := v.Operator
+	if op == "" {
+		op = "eq"
+	}
+	switch op {
+	case "eq", "ne", "gt", "gte", "lt", "lte":
+		n, err := number(v.Value)
+		if err != nil {
+			return err
+		}
+		value = n
+		sign := map[string]string{"eq": "=", "ne": "!=", "gt": ">", "gte": ">=", "lt": "<", "lte": "<="}[op]
+		q.Where = append(q.Where, expr+sign+q.arg(value))
+	case "contains":
+		q.Where = append(q.Where, column+" like "+q.arg("%"+v.Value+"%"))
+	case "prefix":
+		q.Where = append(q.Where, column+" like "+q.arg(v.Value+"%"))
+	case "between":
+		bounds := strings.SplitN(v.Value, ",", 2)
+		for i, bound := range bounds {
+			if bound == "" {
+				continue
+			}
+			n, err := number(bound)
+			if err != nil {
+				return err
+			}
+			sign := ">="
+			if i == 1 {
+				sign = "<="
+			}
+			q.Where = append(q.Where, expr+sign+q.arg(n))
+		}
+	case "in", "nin":
+		placeholders := []string{}
+		for _, item := range strings.Split(v.Value, ",") {
+			n, err := number(item)
+			if err != nil {
+				return err
+			}
+			placeholders = append(placeholders, q.arg(n))
+		}
+		not := ""
+		if op == "nin" {
+			not = "not "
+		}
+		q.Where = append(q.Where, expr+" "+not+"in ("+strings.Join(placeholders, ", ")+")")
+	default:
+		return fmt.Errorf("%s 不支持操作符 %s", field, op)
+	}
+	return nil
+}
+
+// requestPersonQuery 读取 filters (json), 以字段名直接提交的等值条件, keyword 全文搜索,
+// 以及 sort, 多列用逗号分隔, 前面加 - 为降序, 例如 sort=country,-city
+func requestPersonQuery(r *http.Request) (personQuery, error) {
+	q := personQuery{}
+	query := r.URL.Query()
+
+	values := make(map[string]searchValueT)
+	if str := query.Get("filters"); str != "" {
+		err := json.Unmarshal([]byte(str), &values)
+		if err != nil {
+			return q, fmt.Errorf("filters 参数格式错误: %s", err.Error())
+		}
+	}
+	for field := range personColumns {
+		if _, ok := values[field]; ok {
+			continue
+		}
+		if v := query.Get(field); v != "" {
+			values[field] = searchValueT{Value: v}
+		}
+	}
+
+	fields := []string{}
+	for field := range values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if _, ok := personColumns[field]; !ok {
+			return q, fmt.Errorf("没有字段 %s", field)
+		}
+		if err := q.filter(field, values[field]); err != nil {
+			return q, err
+		}
+	}
+
+	if match := ftsMatch(query.Get("keyword")); match != "" {
+		q.Where = append(q.Where, "rowid in (select docid from person_fts where person_fts match "+q.arg(match)+")")
+	}
+
+	for _, item := range strings.Split(query.Get("sort"), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		direction := "asc"
+		if strings.HasPrefix(item, "-") {
+			direction = "desc"
+			item = item[1:]
+		}
+		column, ok := personColumns[item]
+		if !ok {
+			return q, fmt.Errorf("不能按 %s 排序", item)
+		}
+		if personNumberColumns.search(column) {
+			column = "cast(" + column + " as real)"
+		}
+		q.Order = append(q.Order, column+" "+direction)
+	}
+	// 保证分页稳定
+	q.Order = append(q.Order, "rowid asc")
+	return q, nil
+}
+
+func (q personQuery) whereSQL() string {
+	if len(q.Where) == 0 {
+		return ""
+	}
+	return " where " + strings.Join(q.Where, " and ")
+}
+
+func (q personQuery) orderSQL() string {
+	return " order by " + strings.Join(q.Order, ", ")
+}
+
+// indexPersonFTS 建立 person 的全文索引, 并用触发器保持同步
+func indexPersonFTS(tx *sqlx.Tx) {
+	tx.MustExec(`CREATE VIRTUAL TABLE IF NOT EXISTS person_fts USING fts4(content="person", first_name, last_name, email, city, country, prefix="2,3")`)
+	tx.MustExec(`CREATE TRIGGER IF NOT EXISTS person_fts_bu BEFORE UPDATE ON person BEGIN DELETE FROM person_fts WHERE docid=old.rowid; END`)
+	tx.MustExec(`CREATE TRIGGER IF NOT EXISTS person_fts_bd BEFORE DELETE ON person BEGIN DELETE FROM person_fts WHERE docid=old.rowid; END`)
+	tx.MustExec(`CREATE TRIGGER IF NOT EXISTS person_fts_au AFTER UPDATE ON person BEGIN INSERT INTO person_fts(docid, first_name, last_name, email, city, country) VALUES(new.rowid, new.first_name, new.last_name, new.email, new.city, new.country); END`)
+	tx.MustExec(`CREATE TRIGGER IF NOT EXISTS person_fts_ai AFTER INSERT ON person BEGIN INSERT INTO person_fts(docid, first_name, last_name, email, city, country) VALUES(new.rowid, new.first_name, new.last_name, new.email, new.city, new.country); END`)
+	tx.MustExec(`INSERT INTO person_fts(person_fts) VALUES('rebuild')`)
+}
+
+// GeneratePerson 按已有数据随机组合生成 count 条 person, 用于测试大数据量
+func GeneratePerson(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count <= 0 || count > 1000000 {
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "1",
+			Des:  "count 应为 1 到 1000000 之间的整数",
+		})
+		return
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	samples := []Person{}
+	err = db.Select(&samples, "select id, coalesce(first_name, '') as first_name, coalesce(last_name, '') as last_name, coalesce(email, '') as email, coalesce(gender, '') as gender, coalesce(ip_address, '') as ip_address, coalesce(city, '') as city, coalesce(country, '') as country, coalesce(latitude, '') as latitude, coalesce(longitude, '') as longitude, coalesce(guid, '') as guid from person limit 1000")
+	if err != nil {
+		panic(err)
+	}
+	if len(samples) == 0 {
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "1",
+			Des:  "person 中没有可以参考的数据",
+		})
+		return
+	}
+
+	var maxID int
+	err = db.Get(&maxID, "select coalesce(max(id), 0) from person")
+	if err != nil {
+		panic(err)
+	}
+
+	rd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	pick := func() Person { return samples[rd.Intn(len(samples))] }
+
+	tx := db.MustBegin()
+	stmt, err := tx.Preparex("insert into person(id, first_name, last_name, email, gender, ip_address, city, country, latitude, longitude, guid) values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)")
+	if err != nil {
+		panic(err)
+	}
+	for i := 1; i <= count; i++ {
+		first, last, place := pick(), pick(), pick()
+		email := strings.ToLower(strings.Replace(first.FirstName+"."+last.LastName, " ", "", -1)) + strconv.Itoa(maxID+i) + "@example.com"
+		stmt.MustExec(maxID+i, first.FirstName, last.LastName, email, first.Gender, pick().IPAddress,
+			place.City, place.Country, place.Latitude, place.Longitude, xid.New().String())
+	}
+	stmt.Close()
+	tx.Commit()
+
+	json.NewEncoder(w).Encode(resResultT{
+		Code:   "0",
+		Des:    fmt.Sprintf("生成 %d 条", count),
+		Result: map[string]int{"from": maxID + 1, "to": maxID + count},
+	})
+}
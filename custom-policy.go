@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 路由策略, 每次请求时读取 route-policy.json, 修改后不需要重启.
+// pattern 以 / 结尾时匹配前缀, 含 * 时按 path.Match 匹配, 否则完全匹配; 多条匹配时取最长的 pattern.
+// methods 为空时对所有方法生效
+
+type routePolicyT struct {
+	Pattern string   `json:"pattern"`
+	Auth    bool     `json:"auth"`
+	Roles   []string `json:"roles"`
+	Methods []string `json:"methods"`
+}
+
+func routePolicyFile() string {
+	if file := os.Getenv("ROUTE_POLICY"); file != "" {
+		return file
+	}
+	return "route-policy.json"
+}
+
+func readRoutePolicies() []routePolicyT {
+	plan, err := ioutil.ReadFile(routePolicyFile())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		panic(err)
+	}
+
+	policies := []routePolicyT{}
+	err = json.Unmarshal(plan, &policies)
+	if err != nil {
+		panic(err)
+	}
+	return policies
+}
+
+func matchRoute(pattern, p string) bool {
+	switch {
+	case strings.Contains(pattern, "*"):
+		matched, _ := path.Match(pattern, p)
+		return matched
+	case strings.HasSuffix(pattern, "/"):
+		return strings.HasPrefix(p, pattern)
+	}
+	return pattern == p
+}
+
+// routePolicy 找到请求对应的策略, 没有时返回 nil
+func routePolicy(policies []routePolicyT, method, p string) *routePolicyT {
+	var found *routePolicyT
+	for i := range policies {
+		if len(policies[i].Methods) > 0 && !sliceString(policies[i].Methods).search(method) {
+			continue
+		}
+		if matchRoute(policies[i].Pattern, p) && (found == nil || len(policies[i].Pattern) > len(found.Pattern)) {
+			found = &policies[i]
+		}
+	}
+	return found
+}
+
+// protect 按路由策略校验 token 和角色, 未登录返回 401, 角色不足返回 403
+func protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 预检请求不带 token
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		policy := routePolicy(readRoutePolicies(), r.Method, r.URL.Path)
+		if policy == nil || (!policy.Auth && len(policy.Roles) == 0) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "*")
+
+		userID, ok := tokenUserID(w, r)
+		if !ok {
+			return
+		}
+
+		if len(policy.Roles) > 0 {
+			db, err := sqlx.Connect("sqlite3", tenantDB(r))
+			if err != nil {
+				panic(err)
+			}
+
+			roles := sliceString(requestRoles(db, userID))
+
+			db.Close()
+
+			allowed := false
+			for _, role := range policy.Roles {
+				if roles.search(role) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(resResultT{
+					Code:   "403",
+					Des:    "没有权限",
+					Result: map[string]interface{}{"roles": policy.Roles},
+				})
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// seedPolicyUsers 默认用户设为 admin, 另加一个没有角色的 guest / guest 用于测试 403
+func seedPolicyUsers(tx *sqlx.Tx) {
+	var count int
+	err := tx.Get(&count, "select count(*) from user_role where user_id=$1", "0013")
+	if err != nil {
+		panic(err)
+	}
+	if count == 0 {
+		tx.MustExec("insert into user_role(user_id, role, seq) values($1, $2, $3)", "0013", "admin", 0)
+	}
+
+	tx.MustExec("insert or ignore into mock_user(id, username, password_hash, created_at) values($1, $2, $3, $4)",
+		"0014", "guest", hashPassword("guest"), time.Now().Format("2006-01-02 15:04:05"))
+}
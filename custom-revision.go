@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 模块版本号, target 为 table 或 filter, 每次修改 raw_column / raw_filter 加一
+
+func moduleRevision(db *sqlx.DB, moduleID, target string) int {
+	var revision int
+	err := db.Get(&revision, "select coalesce(max(revision), 0) from module_revision where module_id=$1 and target=$2", moduleID, target)
+	if err != nil {
+		panic(err)
+	}
+	return revision
+}
+
+// moduleDefinitions 模块当前的列或过滤定义, 用于冲突时返回
+func moduleDefinitions(db *sqlx.DB, moduleID, target string) interface{} {
+	if target == "filter" {
+		return maintenanceFilters(db, moduleID)
+	}
+	return maintenanceColumns(db, moduleID)
+}
+
+// bumpModuleRevision 只有当前版本号等于 expected 时才加一, 返回是否成功
+func bumpModuleRevision(tx *sqlx.Tx, moduleID, target string, expected int) bool {
+	tx.MustExec("insert or ignore into module_revision(module_id, target, revision) values($1, $2, 0)", moduleID, target)
+	result := tx.MustExec("update module_revision set revision=revision+1 where module_id=$1 and target=$2 and revision=$3", moduleID, target, expected)
+	n, err := result.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+	return n == 1
+}
+
+// requestRevision 读取 If-Match 头, 没有时读取 revision 参数
+func requestRevision(r *http.Request) (int, bool) {
+	str := r.Header.Get("If-Match")
+	if str == "" {
+		revisions := r.URL.Query()["revision"]
+		if len(revisions) >= 1 {
+			str = revisions[0]
+		}
+	}
+
+	str = strings.Trim(strings.TrimPrefix(strings.TrimSpace(str), "W/"), `"`)
+	revision, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, false
+	}
+	return revision, true
+}
+
+// revisionResultT 带版本号的返回, 版本号为 0 时也返回, 和 ETag 头一致
+type revisionResultT struct {
+	Code     string      `json:"code"`
+	Des      string      `json:"des"`
+	Result   interface{} `json:"result"`
+	Revision int         `json:"revision"`
+}
+
+func setRevisionHeader(w http.ResponseWriter, revision int) {
+	w.Header().Set("Access-Control-Expose-Headers", "ETag")
+	w.Header().Set("ETag", `"`+strconv.Itoa(revision)+`"`)
+}
+
+func writeRevisionRequired(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusPreconditionRequired)
+	json.NewEncoder(w).Encode(resResultT{
+		Code: "428",
+		Des:  "缺少 If-Match 或 revision",
+	})
+}
+
+type revisionConflictT struct {
+	ModuleID string `json:"moduleId"`
+	Target   string `json:"target"`
+	Expected int    `json:"expected"`
+	Revision int    `json:"revision"`
+}
+
+// checkRevisions 同时修改多个 target 时逐个对比期望的版本号, 返回是否缺少版本号和不一致的 target
+func checkRevisions(db *sqlx.DB, moduleID string, expected map[string]int, targets ...string) (bool, []revisionConflictT) {
+	conflicts := []revisionConflictT{}
+	for _, target := range targets {
+		revision, ok := expected[target]
+		if !ok {
+			return false, nil
+		}
+		if current := moduleRevision(db, moduleID, target); current != revision {
+			conflicts = append(conflicts, revisionConflictT{
+				ModuleID: moduleID,
+				Target:   target,
+				Expected: revision,
+				Revision: current,
+			})
+		}
+	}
+	return true, conflicts
+}
+
+func writeRevisionConflicts(w http.ResponseWriter, conflicts []revisionConflictT) {
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(resResultT{
+		Code:   "409",
+		Des:    "数据已被其他人修改",
+		Result: conflicts,
+	})
+}
+
+// writeRevisionConflict 返回当前版本和数据, 方便前端合并
+func writeRevisionConflict(w http.ResponseWriter, revision int, current interface{}) {
+	setRevisionHeader(w, revision)
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(revisionResultT{
+		Code:     "409",
+		Des:      "数据已被其他人修改",
+		Result:   current,
+		Revision: revision,
+	})
+}
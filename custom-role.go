@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/xid"
+)
+
+// requestRoles 用户的角色, 按优先级排序
+func requestRoles(db *sqlx.DB, userID string) []string {
+	roles := []string{}
+	err := db.Select(&roles, "select role from user_role where user_id=$1 order by seq", userID)
+	if err != nil {
+		panic(err)
+	}
+	return roles
+}
+
+// layoutRole 在用户的角色中找到第一个为该模块设置过布局的角色
+func layoutRole(db *sqlx.DB, roles []string, table, id string) string {
+	for _, role := range roles {
+		var count int
+		err := db.Get(&count, "select count(*) from "+table+" where role=$1 and module_id=$2", role, id)
+		if err != nil {
+			panic(err)
+		}
+		if count > 0 {
+			return role
+		}
+	}
+	return ""
+}
+
+// GetUserRoles 用户和角色的对应关系
+func GetUserRoles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	type userRolesT struct {
+		UserID string   `json:"userId"`
+		Roles  []string `json:"roles"`
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		// 只有 admin 可以查看其他用户的角色
+		userID, ok := tokenUserID(w, r)
+		if !ok {
+			break
+		}
+		if users := r.URL.Query()["user"]; len(users) >= 1 && users[0] != userID {
+			if !sliceString(requestRoles(db, userID)).search("admin") {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(resResultT{
+					Code:   "403",
+					Des:    "没有权限",
+					Result: map[string]interface{}{"roles": []string{"admin"}},
+				})
+				break
+			}
+			userID = users[0]
+		}
+
+		roles := requestRoles(db, userID)
+
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "0",
+			Des:  "",
+			Result: userRolesT{
+				UserID: userID,
+				Roles:  roles,
+			},
+		})
+	case http.MethodPost:
+		param := userRolesT{}
+		err = json.NewDecoder(r.Body).Decode(&param)
+		if err != nil {
+			panic(err)
+		}
+
+		if param.UserID == "" {
+			panic("userId can not be null")
+		}
+
+		if unknown := unknownRoles(db, param.Roles); len(unknown) > 0 {
+			json.NewEncoder(w).Encode(resResultT{
+				Code:   "1",
+				Des:    "角色校验失败",
+				Result: roleErrors(unknown),
+			})
+			break
+		}
+
+		tx := db.MustBegin()
+		tx.MustExec("delete from user_role where user_id=$1", param.UserID)
+		for i, role := range param.Roles {
+			tx.MustExec("insert or ignore into user_role(user_id, role, seq) values($1, $2, $3)", param.UserID, role, i)
+		}
+		tx.Commit()
+
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "0",
+			Des:  "",
+		})
+	}
+
+	db.Close()
+}
+
+// GetRoleMaintenanceTable 角色表数据
+func GetRoleMaintenanceTable(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	switch r.Method {
+	case http.MethodGet:
+		getRoleMaintenanceTable(w, r)
+	case http.MethodPost:
+		updateRoleMaintenanceTable(w, r)
+	}
+}
+
+func getRoleMaintenanceTable(w http.ResponseWriter, r *http.Request) {
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	roles := r.URL.Query()["role"]
+	role := ""
+	if len(roles) >= 1 {
+		role = roles[0]
+	}
+
+	if id == "" || role == "" {
+		panic("id or role is nil")
+	}
+
+	columns := columnTree(userMaintenanceColumns(db, "", role, id))
+
+	db.Close()
+
+	res := resResultT{
+		Code:   "0",
+		Des:    "",
+		Result: columns,
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func updateRoleMaintenanceTable(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	columns := []userColumn{}
+	err := decoder.Decode(&columns)
+	if err != nil {
+		panic(err)
+	}
+
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	roles := r.URL.Query()["role"]
+	role := ""
+	if len(roles) >= 1 {
+		role = roles[0]
+	}
+
+	if id == "" || role == "" {
+		panic("id or role can not be null")
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	current := make(map[string]userColumn)
+	for _, c := range userMaintenanceColumns(db, "", role, id) {
+		current[c.Value] = c
+	}
+
+	// 只保存与当前合并结果不同的字段, 其余字段继续使用模块的设置
+	tx := db.MustBegin()
+	for i, datum := range flattenColumns(columns) {
+		if datum.Status != "1" {
+			continue
+		}
+		fields := changedColumnFields(current[datum.Value], datum, i, sliceString{"hidden", "frozen", "seq", "location", "rule", "width"})
+		if len(fields) > 0 {
+			upsertLayoutColumn(tx, "role_column", "role", role, id, datum.Value, fields)
+		}
+	}
+	tx.Commit()
+	db.Close()
+
+	res := resResultT{
+		Code: "0",
+		Des:  "",
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// ResetRoleMaintenanceTable 重置角色表数据
+func ResetRoleMaintenanceTable(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	resetRoleLayout(w, r, "role_column")
+}
+
+// GetRoleMaintenanceFilter 角色过滤数据
+func GetRoleMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	switch r.Method {
+	case http.MethodGet:
+		getRoleMaintenanceFilter(w, r)
+	case http.MethodPost:
+		updateRoleMaintenanceFilter(w, r)
+	}
+}
+
+func getRoleMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	roles := r.URL.Query()["role"]
+	role := ""
+	if len(roles) >= 1 {
+		role = roles[0]
+	}
+
+	if id == "" || role == "" {
+		panic("id or role is nil")
+	}
+
+	filter := userMaintenanceFilters(db, "", role, id)
+
+	db.Close()
+
+	res := resResultT{
+		Code:   "0",
+		Des:    "",
+		Result: filter,
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func updateRoleMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	filter := []userFilter{}
+	err := decoder.Decode(&filter)
+	if err != nil {
+		panic(err)
+	}
+
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	roles := r.URL.Query()["role"]
+	role := ""
+	if len(roles) >= 1 {
+		role = roles[0]
+	}
+
+	if id == "" || role == "" {
+		panic("id or role can not be null")
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	allRoleValues := sliceString{}
+	err = db.Select(&allRoleValues, "select value from role_filter where role=$1 and module_id=$2", role, id)
+	if err != nil {
+		panic(err)
+	}
+
+	tx := db.MustBegin()
+	for i, datum := range filter {
+		if datum.Status != "1" {
+			continue
+		}
+		if allRoleValues.search(datum.Value) {
+			tx.MustExec("update role_filter set hidden=$1, seq=$2 where module_id=$3 and role=$4 and value=$5", datum.Hidden, i, id, role, datum.Value)
+		} else {
+			tx.MustExec("insert into role_filter(module_id, role, value, hidden, seq, id) values($1,$2,$3,$4,$5, $6)", id, role, datum.Value, datum.Hidden, i, xid.New().String())
+		}
+	}
+	tx.Commit()
+	db.Close()
+
+	res := resResultT{
+		Code: "0",
+		Des:  "",
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// ResetRoleMaintenanceFilter 重置角色过滤数据
+func ResetRoleMaintenanceFilter(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	resetRoleLayout(w, r, "role_filter")
+}
+
+func resetRoleLayout(w http.ResponseWriter, r *http.Request, table string) {
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	roles := r.URL.Query()["role"]
+	role := ""
+	if len(roles) >= 1 {
+		role = roles[0]
+	}
+
+	if id == "" || role == "" {
+		panic("id or role is nil")
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	tx := db.MustBegin()
+	tx.MustExec("delete from "+table+" where role=$1 and module_id=$2", role, id)
+	tx.Commit()
+	db.Close()
+
+	res := resResultT{
+		Code: "0",
+		Des:  "",
+	}
+	json.NewEncoder(w).Encode(res)
+}
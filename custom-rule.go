@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 列规则 (raw_column.rule / user_column.rule) 由 "|" 分隔的 key:arg 子句组成, 例如
+//
+//	fm:date|df:YYYY/MM/dd
+//	fm:currency|cur:$|prec:2|style:<0?red|edit:status=0
+//	fm:enum|enum:1=中国银行,2=民生银行
+//
+// fm    显示格式 text, date, number, currency, enum
+// df    日期格式, 支持 YYYY MM DD(dd) HH mm ss, 仅用于 fm:date
+// prec  小数位数 0-10, 用于 fm:number 和 fm:currency
+// cur   货币符号, 默认 ¥, 仅用于 fm:currency
+// enum  code=label 列表, 逗号分隔, 仅用于 fm:enum
+// min   最小值 (数字) 或最小长度 (文本)
+// max   最大值 (数字) 或最大长度 (文本)
+// style 条件样式, cond?class 列表, 分号分隔
+// edit  是否可编辑, 0 或 1 或 cond 列表 (全部满足才可编辑)
+//
+// cond 的格式为 [field]op literal, op 为 = != > >= < <= ~(包含),
+// 省略 field 时比较本列的值.
+
+var ruleFormats = sliceString{"text", "date", "number", "currency", "enum"}
+
+type ruleCondT struct {
+	Field   string
+	Op      string
+	Literal string
+}
+
+type ruleStyleT struct {
+	Cond  ruleCondT
+	Class string
+}
+
+type ruleEnumT struct {
+	Code  string
+	Label string
+}
+
+type ruleT struct {
+	Format     string
+	DateFormat string
+	Precision  int
+	Currency   string
+	Enum       []ruleEnumT
+	Min        *float64
+	Max        *float64
+	Styles     []ruleStyleT
+	Editable   bool
+	EditConds  []ruleCondT
+}
+
+type ruleEvalT struct {
+	Display  string   `json:"display"`
+	Styles   []string `json:"styles"`
+	Editable bool     `json:"editable"`
+	Valid    bool     `json:"valid"`
+}
+
+func parseRuleCond(str string) (ruleCondT, error) {
+	i := strings.IndexAny(str, "=!<>~")
+	if i < 0 {
+		return ruleCondT{}, fmt.Errorf("条件 %q 缺少运算符", str)
+	}
+
+	op := str[i : i+1]
+	if i+1 < len(str) && str[i+1] == '=' && op != "=" && op != "~" {
+		op += "="
+	}
+	if op == "!" {
+		return ruleCondT{}, fmt.Errorf("条件 %q 运算符无效", str)
+	}
+
+	return ruleCondT{
+		Field:   strings.TrimSpace(str[:i]),
+		Op:      op,
+		Literal: strings.TrimSpace(str[i+len(op):]),
+	}, nil
+}
+
+func parseRule(str string) (ruleT, error) {
+	rule := ruleT{
+		Format:    "text",
+		Precision: -1,
+		Editable:  true,
+	}
+
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return rule, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, clause := range strings.Split(str, "|") {
+		kv := strings.SplitN(clause, ":", 2)
+		if len(kv) != 2 {
+			return rule, fmt.Errorf("子句 %q 应为 key:arg", clause)
+		}
+		key, arg := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if seen[key] {
+			return rule, fmt.Errorf("%s 重复出现", key)
+		}
+		seen[key] = true
+
+		switch key {
+		case "fm":
+			if !ruleFormats.search(arg) {
+				return rule, fmt.Errorf("fm 不支持 %q", arg)
+			}
+			rule.Format = arg
+		case "df":
+			if !strings.Contains(arg, "YYYY") && !strings.Contains(arg, "MM") && !strings.Contains(arg, "HH") {
+				return rule, fmt.Errorf("df %q 不是有效的日期格式", arg)
+			}
+			rule.DateFormat = arg
+		case "prec":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 || n > 10 {
+				return rule, fmt.Errorf("prec %q 应为 0-10 的整数", arg)
+			}
+			rule.Precision = n
+		case "cur":
+			if arg == "" {
+				return rule, fmt.Errorf("cur 不能为空")
+			}
+			rule.Currency = arg
+		case "enum":
+			for _, item := range strings.Split(arg, ",") {
+				pair := strings.SplitN(item, "=", 2)
+				if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+					return rule, fmt.Errorf("enum 项 %q 应为 code=label", item)
+				}
+				rule.Enum = append(rule.Enum, ruleEnumT{
+					Code:  strings.TrimSpace(pair[0]),
+					Label: strings.TrimSpace(pair[1]),
+				})
+			}
+		case "min", "max":
+			f, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return rule, fmt.Errorf("%s %q 应为数字", key, arg)
+			}
+			if key == "min" {
+				rule.Min = &f
+			} else {
+				rule.Max = &f
+			}
+		case "style":
+			for _, item := range strings.Split(arg, ";") {
+				pair := strings.SplitN(item, "?", 2)
+				if len(pair) != 2 || strings.TrimSpace(pair[1]) == "" {
+					return rule, fmt.Errorf("style 项 %q 应为 cond?class", item)
+				}
+				cond, err := parseRuleCond(pair[0])
+				if err != nil {
+					return rule, err
+				}
+				rule.Styles = append(rule.Styles, ruleStyleT{
+					Cond:  cond,
+					Class: strings.TrimSpace(pair[1]),
+				})
+			}
+		case "edit":
+			if arg == "0" || arg == "1" {
+				rule.Editable = arg == "1"
+				continue
+			}
+			for _, item := range strings.Split(arg, ";") {
+				cond, err := parseRuleCond(item)
+				if err != nil {
+					return rule, err
+				}
+				rule.EditConds = append(rule.EditConds, cond)
+			}
+		default:
+			return rule, fmt.Errorf("不支持的规则 %q", key)
+		}
+	}
+
+	if rule.DateFormat != "" && rule.Format != "date" {
+		return rule, fmt.Errorf("df 只能用于 fm:date")
+	}
+	if rule.Precision >= 0 && rule.Format != "number" && rule.Format != "currency" {
+		return rule, fmt.Errorf("prec 只能用于 fm:number 或 fm:currency")
+	}
+	if rule.Currency != "" && rule.Format != "currency" {
+		return rule, fmt.Errorf("cur 只能用于 fm:currency")
+	}
+	if (rule.Enum != nil) != (rule.Format == "enum") {
+		return rule, fmt.Errorf("fm:enum 与 enum 必须同时设置")
+	}
+	if rule.Min != nil && rule.Max != nil && *rule.Min > *rule.Max {
+		return rule, fmt.Errorf("min 不能大于 max")
+	}
+
+	return rule, nil
+}
+
+func ruleRowValue(row map[string]interface{}, field string) string {
+	v, ok := row[field]
+	if !ok || v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func (cond ruleCondT) match(value string, row map[string]interface{}) bool {
+	if cond.Field != "" {
+		value = ruleRowValue(row, cond.Field)
+	}
+
+	if cond.Op == "~" {
+		return strings.Contains(value, cond.Literal)
+	}
+
+	var cmp int
+	a, errA := strconv.ParseFloat(value, 64)
+	b, errB := strconv.ParseFloat(cond.Literal, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(value, cond.Literal)
+	}
+
+	switch cond.Op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+var ruleDateLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02",
+	time.RFC3339,
+}
+
+var ruleDateReplacer = strings.NewReplacer(
+	"YYYY", "2006", "MM", "01", "DD", "02", "dd", "02", "HH", "15", "mm", "04", "ss", "05",
+)
+
+// groupThousands 给整数部分加上千分位
+func groupThousands(s string) string {
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	intPart, fracPart := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		intPart, fracPart = s[:i], s[i:]
+	}
+	for i := len(intPart) - 3; i > 0; i -= 3 {
+		intPart = intPart[:i] + "," + intPart[i:]
+	}
+	return sign + intPart + fracPart
+}
+
+func (rule ruleT) eval(value string, row map[string]interface{}) ruleEvalT {
+	res := ruleEvalT{
+		Display:  value,
+		Styles:   []string{},
+		Editable: rule.Editable,
+		Valid:    true,
+	}
+
+	switch rule.Format {
+	case "date":
+		parsed := false
+		for _, layout := range ruleDateLayouts {
+			t, err := time.Parse(layout, value)
+			if err == nil {
+				if rule.DateFormat != "" {
+					res.Display = t.Format(ruleDateReplacer.Replace(rule.DateFormat))
+				}
+				parsed = true
+				break
+			}
+		}
+		if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+			if rule.DateFormat != "" {
+				res.Display = time.Unix(0, ms*int64(time.Millisecond)).Format(ruleDateReplacer.Replace(rule.DateFormat))
+			}
+			parsed = true
+		}
+		if !parsed {
+			res.Valid = value == ""
+		}
+	case "number", "currency":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			res.Valid = value == ""
+			break
+		}
+		prec := rule.Precision
+		if prec < 0 && rule.Format == "currency" {
+			prec = 2
+		}
+		res.Display = strconv.FormatFloat(f, 'f', prec, 64)
+		if rule.Format == "currency" {
+			symbol := rule.Currency
+			if symbol == "" {
+				symbol = "¥"
+			}
+			res.Display = groupThousands(res.Display)
+			if strings.HasPrefix(res.Display, "-") {
+				res.Display = "-" + symbol + res.Display[1:]
+			} else {
+				res.Display = symbol + res.Display
+			}
+		}
+		if rule.Min != nil && f < *rule.Min || rule.Max != nil && f > *rule.Max {
+			res.Valid = false
+		}
+	case "enum":
+		for _, e := range rule.Enum {
+			if e.Code == value {
+				res.Display = e.Label
+			}
+		}
+	}
+
+	if rule.Format == "text" || rule.Format == "enum" {
+		n := float64(len([]rune(value)))
+		if rule.Min != nil && n < *rule.Min || rule.Max != nil && n > *rule.Max {
+			res.Valid = false
+		}
+	}
+
+	for _, style := range rule.Styles {
+		if style.Cond.match(value, row) {
+			res.Styles = append(res.Styles, style.Class)
+		}
+	}
+
+	for _, cond := range rule.EditConds {
+		if !cond.match(value, row) {
+			res.Editable = false
+		}
+	}
+
+	return res
+}
+
+// EvalMaintenanceRule 规则预览
+func EvalMaintenanceRule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	type evalParamT struct {
+		Rule  string                 `json:"rule"`
+		Value string                 `json:"value"`
+		Row   map[string]interface{} `json:"row"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	param := evalParamT{}
+	err := decoder.Decode(&param)
+	if err != nil {
+		panic(err)
+	}
+
+	rule, err := parseRule(param.Rule)
+	if err != nil {
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "1",
+			Des:  err.Error(),
+		})
+		return
+	}
+
+	res := resResultT{
+		Code:   "0",
+		Des:    "",
+		Result: rule.eval(ruleRowValue(param.Row, param.Value), param.Row),
+	}
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/xid"
+)
+
+// 用户保存的查询, filter_values 以 json 保存 value -> {operator, value}
+
+type searchValueT struct {
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+type userSearchT struct {
+	ID        string   `db:"id" json:"id"`
+	MoudleID  string   `db:"module_id" json:"moduleId"`
+	UserID    string   `db:"user_id" json:"userId"`
+	Name      string   `db:"name" json:"name"`
+	Shared    string   `db:"shared" json:"shared"`
+	IsDefault string   `db:"is_default" json:"isDefault"`
+	Values    jsonText `db:"filter_values" json:"values"`
+	Created   string   `db:"created_at" json:"createdAt"`
+	Updated   string   `db:"updated_at" json:"updatedAt"`
+	Mine      bool     `db:"mine" json:"mine"`
+}
+
+// defaultSearchValues 用户在该模块上设为默认的查询, 没有时返回 nil
+func defaultSearchValues(db *sqlx.DB, userID, id string) map[string]searchValueT {
+	var str string
+	err := db.Get(&str, "select filter_values from user_search where user_id=$1 and module_id=$2 and is_default='1'", userID, id)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		panic(err)
+	}
+
+	values := make(map[string]searchValueT)
+	err = json.Unmarshal([]byte(str), &values)
+	if err != nil {
+		panic(err)
+	}
+	return values
+}
+
+// checkSearchValues 查询条件必须对应模块中的过滤, 且操作符是该过滤允许的
+func checkSearchValues(filters []rawFilter, values map[string]searchValueT) []string {
+	definitions := make(map[string]rawFilter)
+	for _, f := range filters {
+		definitions[f.Value] = f
+	}
+
+	errors := []string{}
+	for value, v := range values {
+		f, ok := definitions[value]
+		if !ok {
+			errors = append(errors, fmt.Sprintf("过滤 %s 不存在", value))
+			continue
+		}
+		operators := filterOperators(f.Type, f.Operators)
+		if v.Operator == "" {
+			continue
+		}
+		if !sliceString(strings.Split(operators, ",")).search(v.Operator) {
+			errors = append(errors, fmt.Sprintf("过滤 %s 不支持操作符 %s", value, v.Operator))
+		}
+	}
+	return errors
+}
+
+// UserMaintenanceSearch 用户保存的查询
+func UserMaintenanceSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+
+	switch r.Method {
+	case http.MethodGet:
+		getUserMaintenanceSearch(w, r)
+	case http.MethodPost:
+		saveUserMaintenanceSearch(w, r)
+	case http.MethodDelete:
+		deleteUserMaintenanceSearch(w, r)
+	}
+}
+
+func getUserMaintenanceSearch(w http.ResponseWriter, r *http.Request) {
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	userID, ok := tokenUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if id == "" || userID == "" {
+		panic("id or token is nil")
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	// 自己的查询在前, 其次是别人共享的
+	searches := []userSearchT{}
+	err = db.Select(&searches, `
+						select id, module_id, user_id, name, shared, is_default,
+						filter_values, created_at, updated_at, user_id=$1 as mine
+						from user_search
+						where module_id=$2 and (user_id=$1 or shared='1')
+						order by mine desc, name asc
+						`, userID, id)
+	if err != nil {
+		panic(err)
+	}
+
+	// 默认查询只对自己生效
+	for i := range searches {
+		if !searches[i].Mine {
+			searches[i].IsDefault = "0"
+		}
+	}
+
+	db.Close()
+
+	res := resResultT{
+		Code:   "0",
+		Des:    "",
+		Result: searches,
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func saveUserMaintenanceSearch(w http.ResponseWriter, r *http.Request) {
+	type searchParamT struct {
+		ID        string                  `json:"id"`
+		Name      string                  `json:"name"`
+		Shared    string                  `json:"shared"`
+		IsDefault string                  `json:"isDefault"`
+		Values    map[string]searchValueT `json:"values"`
+	}
+
+	param := searchParamT{}
+	err := json.NewDecoder(r.Body).Decode(&param)
+	if err != nil {
+		panic(err)
+	}
+
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	userID, ok := tokenUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if id == "" || userID == "" {
+		panic("id or token can not be null")
+	}
+
+	if param.Name == "" {
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "1",
+			Des:  "查询名称不能为空",
+		})
+		return
+	}
+	if param.Shared != "1" {
+		param.Shared = "0"
+	}
+	if param.IsDefault != "1" {
+		param.IsDefault = "0"
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	if errors := checkSearchValues(maintenanceFilters(db, id), param.Values); len(errors) > 0 {
+		db.Close()
+		json.NewEncoder(w).Encode(resResultT{
+			Code:   "1",
+			Des:    "查询条件校验失败",
+			Result: errors,
+		})
+		return
+	}
+
+	values, err := json.Marshal(param.Values)
+	if err != nil {
+		panic(err)
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+
+	tx := db.MustBegin()
+	if param.IsDefault == "1" {
+		tx.MustExec("update user_search set is_default='0' where user_id=$1 and module_id=$2", userID, id)
+	}
+
+	if param.ID == "" {
+		param.ID = xid.New().String()
+		tx.MustExec("insert into user_search(id, module_id, user_id, name, shared, is_default, filter_values, created_at, updated_at) values($1,$2,$3,$4,$5,$6,$7,$8,$8)",
+			param.ID, id, userID, param.Name, param.Shared, param.IsDefault, string(values), now)
+	} else {
+		result := tx.MustExec("update user_search set name=$1, shared=$2, is_default=$3, filter_values=$4, updated_at=$5 where id=$6 and user_id=$7 and module_id=$8",
+			param.Name, param.Shared, param.IsDefault, string(values), now, param.ID, userID, id)
+		n, err := result.RowsAffected()
+		if err != nil {
+			panic(err)
+		}
+		if n == 0 {
+			tx.Rollback()
+			db.Close()
+			json.NewEncoder(w).Encode(resResultT{
+				Code: "1",
+				Des:  "查询不存在或不属于当前用户",
+			})
+			return
+		}
+	}
+	tx.Commit()
+	db.Close()
+
+	res := resResultT{
+		Code:   "0",
+		Des:    "",
+		Result: map[string]string{"id": param.ID},
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+func deleteUserMaintenanceSearch(w http.ResponseWriter, r *http.Request) {
+	searches := r.URL.Query()["search"]
+	search := ""
+	if len(searches) >= 1 {
+		search = searches[0]
+	}
+
+	userID, ok := tokenUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if search == "" || userID == "" {
+		panic("search or token is nil")
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	result := db.MustExec("delete from user_search where id=$1 and user_id=$2", search, userID)
+	n, err := result.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+
+	db.Close()
+
+	if n == 0 {
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "1",
+			Des:  "查询不存在或不属于当前用户",
+		})
+		return
+	}
+
+	res := resResultT{
+		Code: "0",
+		Des:  "",
+	}
+	json.NewEncoder(w).Encode(res)
+}
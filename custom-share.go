@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/xid"
+)
+
+// 用户自定义布局中可以被复制的字段
+var shareTables = []struct {
+	table  string
+	fields []string
+}{
+	{"user_column", []string{"hidden", "frozen", "seq", "location", "width", "rule"}},
+	{"user_filter", []string{"hidden", "seq"}},
+}
+
+type layoutChangeT struct {
+	Table  string                 `json:"table"`
+	UserID string                 `json:"userId,omitempty"`
+	Value  string                 `json:"value"`
+	Action string                 `json:"action"`
+	Before map[string]interface{} `json:"before,omitempty"`
+	After  map[string]interface{} `json:"after,omitempty"`
+}
+
+type layoutChangeResultT struct {
+	DryRun    bool            `json:"dryRun"`
+	Changes   []layoutChangeT `json:"changes"`
+	Revisions map[string]int  `json:"revisions,omitempty"`
+}
+
+// scanLayoutRows 按 value 读取布局行, 字段值统一为 string 或 nil
+func scanLayoutRows(tx *sqlx.Tx, query string, args ...interface{}) map[string]map[string]interface{} {
+	rows, err := tx.Queryx(query, args...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	result := make(map[string]map[string]interface{})
+	for rows.Next() {
+		row := make(map[string]interface{})
+		err = rows.MapScan(row)
+		if err != nil {
+			panic(err)
+		}
+		for k, v := range row {
+			switch v := v.(type) {
+			case nil:
+			case []byte:
+				row[k] = string(v)
+			default:
+				row[k] = fmt.Sprint(v)
+			}
+		}
+		result[row["value"].(string)] = row
+	}
+	return result
+}
+
+// sortedLayoutValues 保证输出的变更顺序稳定
+func sortedLayoutValues(rows map[string]map[string]interface{}) []string {
+	values := []string{}
+	for value := range rows {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func pickLayoutFields(row map[string]interface{}, fields []string) map[string]interface{} {
+	picked := make(map[string]interface{})
+	for _, field := range fields {
+		picked[field] = row[field]
+	}
+	return picked
+}
+
+func layoutFieldsEqual(a, b map[string]interface{}, fields []string) bool {
+	for _, field := range fields {
+		if a[field] != b[field] {
+			return false
+		}
+	}
+	return true
+}
+
+// ShareUserMaintenanceLayout 复制用户布局给其他用户
+func ShareUserMaintenanceLayout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	type shareParamT struct {
+		From   string   `json:"from"`
+		To     []string `json:"to"`
+		DryRun bool     `json:"dryRun"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	param := shareParamT{}
+	err := decoder.Decode(&param)
+	if err != nil {
+		panic(err)
+	}
+
+	if id == "" || param.From == "" || len(param.To) == 0 {
+		panic("id, from or to can not be null")
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	tx := db.MustBegin()
+	changes := []layoutChangeT{}
+
+	for _, st := range shareTables {
+		selectSQL := "select value, id, " + strings.Join(st.fields, ", ") + " from " + st.table + " where module_id=$1 and user_id=$2"
+		source := scanLayoutRows(tx, selectSQL, id, param.From)
+
+		for _, to := range param.To {
+			if to == param.From {
+				continue
+			}
+			target := scanLayoutRows(tx, selectSQL, id, to)
+
+			for _, value := range sortedLayoutValues(source) {
+				after := pickLayoutFields(source[value], st.fields)
+				if row, ok := target[value]; ok {
+					if layoutFieldsEqual(row, after, st.fields) {
+						continue
+					}
+					changes = append(changes, layoutChangeT{
+						Table:  st.table,
+						UserID: to,
+						Value:  value,
+						Action: "update",
+						Before: pickLayoutFields(row, st.fields),
+						After:  after,
+					})
+					if !param.DryRun {
+						args := []interface{}{}
+						sqlStr := "update " + st.table + " set "
+						for i, field := range st.fields {
+							sqlStr += fmt.Sprintf("%s=$%d, ", field, i+1)
+							args = append(args, after[field])
+						}
+						sqlStr += fmt.Sprintf("updated_at=$%d where id=$%d", len(args)+1, len(args)+2)
+						tx.MustExec(sqlStr, append(args, now, row["id"])...)
+					}
+				} else {
+					changes = append(changes, layoutChangeT{
+						Table:  st.table,
+						UserID: to,
+						Value:  value,
+						Action: "insert",
+						After:  after,
+					})
+					if !param.DryRun {
+						args := []interface{}{id, to, value, xid.New().String(), now}
+						sqlStr := "insert into " + st.table + "(module_id, user_id, value, id, updated_at"
+						placeholders := "$1, $2, $3, $4, $5"
+						for i, field := range st.fields {
+							sqlStr += ", " + field
+							placeholders += fmt.Sprintf(", $%d", i+6)
+							args = append(args, after[field])
+						}
+						tx.MustExec(sqlStr+") values("+placeholders+")", args...)
+					}
+				}
+			}
+
+			// 来源用户没有的设置在目标用户上也要去掉, 保证两边布局一致
+			for _, value := range sortedLayoutValues(target) {
+				if _, ok := source[value]; ok {
+					continue
+				}
+				changes = append(changes, layoutChangeT{
+					Table:  st.table,
+					UserID: to,
+					Value:  value,
+					Action: "delete",
+					Before: pickLayoutFields(target[value], st.fields),
+				})
+				if !param.DryRun {
+					tx.MustExec("delete from "+st.table+" where id=$1", target[value]["id"])
+				}
+			}
+		}
+	}
+
+	if param.DryRun {
+		tx.Rollback()
+	} else {
+		tx.Commit()
+	}
+	db.Close()
+
+	res := resResultT{
+		Code: "0",
+		Des:  "",
+		Result: layoutChangeResultT{
+			DryRun:  param.DryRun,
+			Changes: changes,
+		},
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// PromoteUserMaintenanceLayout 把用户布局设为模块默认布局
+func PromoteUserMaintenanceLayout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	// revisions 为 table 和 filter 当前的版本号, 只有 dryRun 时可以省略
+	type promoteParamT struct {
+		From      string         `json:"from"`
+		DryRun    bool           `json:"dryRun"`
+		Revisions map[string]int `json:"revisions"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	param := promoteParamT{}
+	err := decoder.Decode(&param)
+	if err != nil {
+		panic(err)
+	}
+
+	if id == "" || param.From == "" {
+		panic("id or from can not be null")
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	// 用户层的 hidden, frozen, width 在模块默认层没有对应字段, 只提升顺序, 位置和规则
+	promoteTables := []struct {
+		raw    string
+		target string
+		fields []string
+	}{
+		{"raw_column", "table", []string{"seq", "location", "rule"}},
+		{"raw_filter", "filter", []string{"seq"}},
+	}
+
+	// 提升的是用户实际看到的布局: 与 GET 一样按 用户 -> 角色 -> 模块 合并, 列按多级表头展开后的顺序
+	roles := requestRoles(db, param.From)
+	merged := map[string][]map[string]string{}
+	columnRole := layoutRole(db, roles, "role_column", id)
+	for _, c := range flattenColumns(columnTree(userMaintenanceColumns(db, param.From, columnRole, id))) {
+		merged["table"] = append(merged["table"], map[string]string{"value": c.Value, "location": c.Location, "rule": c.Rule})
+	}
+	filterRole := layoutRole(db, roles, "role_filter", id)
+	for _, f := range userMaintenanceFilters(db, param.From, filterRole, id) {
+		merged["filter"] = append(merged["filter"], map[string]string{"value": f.Value})
+	}
+
+	if !param.DryRun {
+		ok, conflicts := checkRevisions(db, id, param.Revisions, "table", "filter")
+		if !ok {
+			db.Close()
+			writeRevisionRequired(w)
+			return
+		}
+		if len(conflicts) > 0 {
+			db.Close()
+			writeRevisionConflicts(w, conflicts)
+			return
+		}
+	}
+
+	tx := db.MustBegin()
+	changes := []layoutChangeT{}
+	revisions := make(map[string]int)
+
+	for _, pt := range promoteTables {
+		raw := scanLayoutRows(tx, "select value, id, "+strings.Join(pt.fields, ", ")+" from "+pt.raw+" where module_id=$1", id)
+
+		revision := 0
+		for i, m := range merged[pt.target] {
+			value := m["value"]
+			if _, ok := raw[value]; !ok {
+				continue
+			}
+			before := pickLayoutFields(raw[value], pt.fields)
+			after := pickLayoutFields(raw[value], pt.fields)
+			after["seq"] = fmt.Sprint(i)
+			for _, field := range pt.fields {
+				// 库中的 NULL 与合并后的空字符串视为相同
+				if v, ok := m[field]; ok && (v != "" || before[field] != nil) {
+					after[field] = v
+				}
+			}
+			if layoutFieldsEqual(before, after, pt.fields) {
+				continue
+			}
+
+			changes = append(changes, layoutChangeT{
+				Table:  pt.raw,
+				Value:  value,
+				Action: "update",
+				Before: before,
+				After:  after,
+			})
+			if !param.DryRun {
+				args := []interface{}{}
+				sqlStr := "update " + pt.raw + " set "
+				for i, field := range pt.fields {
+					sqlStr += fmt.Sprintf("%s=$%d, ", field, i+1)
+					args = append(args, after[field])
+				}
+				sqlStr = strings.TrimSuffix(sqlStr, ", ") + fmt.Sprintf(" where id=$%d", len(args)+1)
+				if revision == 0 {
+					expected := param.Revisions[pt.target]
+					if !bumpModuleRevision(tx, id, pt.target, expected) {
+						tx.Rollback()
+						_, conflicts := checkRevisions(db, id, param.Revisions, pt.target)
+						db.Close()
+						writeRevisionConflicts(w, conflicts)
+						return
+					}
+					revision = expected + 1
+					revisions[pt.target] = revision
+				}
+				rowID := raw[value]["id"].(string)
+				before := snapshotRow(tx, pt.raw, rowID)
+				tx.MustExec(sqlStr, append(args, rowID)...)
+				recordHistory(tx, id, pt.target, revision, requestActor(r), "update", rowID, before, snapshotRow(tx, pt.raw, rowID))
+			}
+		}
+	}
+
+	if param.DryRun {
+		tx.Rollback()
+	} else {
+		tx.Commit()
+	}
+	db.Close()
+
+	res := resResultT{
+		Code: "0",
+		Des:  "",
+		Result: layoutChangeResultT{
+			DryRun:    param.DryRun,
+			Changes:   changes,
+			Revisions: revisions,
+		},
+	}
+	json.NewEncoder(w).Encode(res)
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/rs/xid"
@@ -24,21 +28,28 @@ type rawColumn struct {
 	ID       string `db:"id" json:"id"`
 	Location string `db:"location" json:"location"`
 	Rule     string `db:"rule" json:"rule"`
+	Parent   string `db:"parent" json:"parent"`
+	MinWidth int    `db:"min_width" json:"minWidth"`
+	MaxWidth int    `db:"max_width" json:"maxWidth"`
 	Status   string `json:"status"`
 }
 
 type userColumn struct {
-	Name     string `db:"name" json:"name"`
-	Value    string `db:"value" json:"value"`
-	Fixed    string `db:"fixed" json:"fixed"`
-	Hidden   string `db:"hidden" json:"hidden"`
-	Frozen   string `db:"frozen" json:"frozen"`
-	ID       string `db:"id" json:"id"`
-	Location string `db:"location" json:"location"`
-	Rule     string `db:"rule" json:"rule"`
-	Status   string `json:"status" json:"-"`
-	Seq      string `db:"seq" json:"-"`
-	Width    string `db:"width" json:"width"`
+	Name     string       `db:"name" json:"name"`
+	Value    string       `db:"value" json:"value"`
+	Fixed    string       `db:"fixed" json:"fixed"`
+	Hidden   string       `db:"hidden" json:"hidden"`
+	Frozen   string       `db:"frozen" json:"frozen"`
+	ID       string       `db:"id" json:"id"`
+	Location string       `db:"location" json:"location"`
+	Rule     string       `db:"rule" json:"rule"`
+	Status   string       `json:"status" json:"-"`
+	Seq      string       `db:"seq" json:"-"`
+	Width    string       `db:"width" json:"width"`
+	MinWidth int          `db:"min_width" json:"minWidth"`
+	MaxWidth int          `db:"max_width" json:"maxWidth"`
+	Parent   string       `db:"parent" json:"parent"`
+	Children []userColumn `json:"children,omitempty"`
 }
 
 // GetMaintenanceTable 运维表数据
@@ -57,7 +68,7 @@ func GetMaintenanceTable(w http.ResponseWriter, r *http.Request) {
 
 func getMaintenanceTable(w http.ResponseWriter, r *http.Request) {
 
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -68,22 +79,30 @@ func getMaintenanceTable(w http.ResponseWriter, r *http.Request) {
 		id = ids[0]
 	}
 
-	columns := []rawColumn{}
-	err = db.Select(&columns, "select id, name, value, fixed, location, rule from `raw_column` where module_id = $1 order by seq", id)
-	if err != nil {
-		panic(err)
-	}
+	columns := maintenanceColumns(db, id)
+	revision := moduleRevision(db, id, "table")
 
 	db.Close()
 
-	res := resResultT{
-		Code:   "0",
-		Des:    "",
-		Result: columns,
+	setRevisionHeader(w, revision)
+	res := revisionResultT{
+		Code:     "0",
+		Des:      "",
+		Result:   columns,
+		Revision: revision,
 	}
 	json.NewEncoder(w).Encode(res)
 }
 
+func maintenanceColumns(db *sqlx.DB, id string) []rawColumn {
+	columns := []rawColumn{}
+	err := db.Select(&columns, "select id, name, value, fixed, location, rule, parent, coalesce(min_width, 0) as min_width, coalesce(max_width, 0) as max_width from `raw_column` where module_id = $1 order by seq", id)
+	if err != nil {
+		panic(err)
+	}
+	return columns
+}
+
 func updateMaintenanceTable(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	columns := []rawColumn{}
@@ -92,7 +111,7 @@ func updateMaintenanceTable(w http.ResponseWriter, r *http.Request) {
 		panic(err)
 	}
 
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		panic(err)
 	}
@@ -103,23 +122,136 @@ func updateMaintenanceTable(w http.ResponseWriter, r *http.Request) {
 		id = ids[0]
 	}
 
+	// 库中已有的旧规则可能不符合现在的语法, 没有修改的规则不再校验
+	storedRules := make(map[string]string)
+	for _, c := range maintenanceColumns(db, id) {
+		storedRules[c.ID] = c.Rule
+	}
+
+	type ruleErrorT struct {
+		Name  string `json:"name,omitempty"`
+		Value string `json:"value"`
+		Rule  string `json:"rule"`
+		Error string `json:"error"`
+	}
+
+	ruleErrors := []ruleErrorT{}
+	for _, datum := range columns {
+		if datum.Status != "0" && datum.Status != "1" {
+			continue
+		}
+		if datum.Value == "" {
+			ruleErrors = append(ruleErrors, ruleErrorT{
+				Name:  datum.Name,
+				Error: "value 不能为空",
+			})
+			continue
+		}
+		if rule, ok := storedRules[datum.ID]; ok && datum.Status == "1" && rule == datum.Rule {
+			// 规则没有修改
+		} else if _, err := parseRule(datum.Rule); err != nil {
+			ruleErrors = append(ruleErrors, ruleErrorT{
+				Value: datum.Value,
+				Rule:  datum.Rule,
+				Error: err.Error(),
+			})
+		}
+		if err := checkWidthRange(datum.MinWidth, datum.MaxWidth); err != nil {
+			ruleErrors = append(ruleErrors, ruleErrorT{
+				Value: datum.Value,
+				Error: err.Error(),
+			})
+		}
+	}
+	if len(ruleErrors) > 0 {
+		db.Close()
+		json.NewEncoder(w).Encode(resResultT{
+			Code:   "1",
+			Des:    "列定义校验失败",
+			Result: ruleErrors,
+		})
+		return
+	}
+
+	revision, ok := requestRevision(r)
+	if !ok {
+		db.Close()
+		writeRevisionRequired(w)
+		return
+	}
+
 	tx := db.MustBegin()
 
+	if !bumpModuleRevision(tx, id, "table", revision) {
+		tx.Rollback()
+		current := maintenanceColumns(db, id)
+		currentRevision := moduleRevision(db, id, "table")
+		db.Close()
+		writeRevisionConflict(w, currentRevision, current)
+		return
+	}
+
+	// 删除只作用于当前模块, 同时删除用户在该模块上的个性化设置
+	type removedT struct {
+		Value    string `json:"value"`
+		Rows     int    `json:"rows"`
+		UserRows int64  `json:"userRows"`
+	}
+	removed := []removedT{}
+
+	actor := requestActor(r)
 	for i, datum := range columns {
 		if datum.Status == "0" {
-			tx.MustExec("insert into raw_column(name, value, module_id, fixed, location, seq, id, rule) values($1,$2,$3,$4,$5, $6, $7, $8)", datum.Name, datum.Value, id, datum.Fixed, datum.Location, i, xid.New().String(), datum.Rule)
+			rowID := xid.New().String()
+			tx.MustExec("insert into raw_column(name, value, module_id, fixed, location, seq, id, rule, parent, min_width, max_width) values($1,$2,$3,$4,$5, $6, $7, $8, $9, $10, $11)", datum.Name, datum.Value, id, datum.Fixed, datum.Location, i, rowID, datum.Rule, datum.Parent, datum.MinWidth, datum.MaxWidth)
+			recordHistory(tx, id, "table", revision+1, actor, "insert", rowID, nil, snapshotRow(tx, "raw_column", rowID))
 		} else if datum.Status == "1" {
-			tx.MustExec("update `raw_column` set name=$1, value=$2, location=$3, fixed=$4, seq=$5, rule=$6 where module_id=$7 and id=$8", datum.Name, datum.Value, datum.Location, datum.Fixed, i, datum.Rule, id, datum.ID)
+			before := snapshotRow(tx, "raw_column", datum.ID)
+			tx.MustExec("update `raw_column` set name=$1, value=$2, location=$3, fixed=$4, seq=$5, rule=$6, parent=$7, min_width=$8, max_width=$9 where module_id=$10 and id=$11", datum.Name, datum.Value, datum.Location, datum.Fixed, i, datum.Rule, datum.Parent, datum.MinWidth, datum.MaxWidth, id, datum.ID)
+			recordHistory(tx, id, "table", revision+1, actor, "update", datum.ID, before, snapshotRow(tx, "raw_column", datum.ID))
 		} else if datum.Status == "2" {
-			tx.MustExec("delete from `raw_column` where value=$1", datum.Value)
+			deleted := snapshotRows(tx, "raw_column", "value=$1 and module_id=$2", datum.Value, id)
+			tx.MustExec("delete from `raw_column` where value=$1 and module_id=$2", datum.Value, id)
+			userRows := deleteCustomizations(tx, id, "table", revision+1, actor, datum.Value)
+			if len(deleted) > 0 || userRows > 0 {
+				removed = append(removed, removedT{
+					Value:    datum.Value,
+					Rows:     len(deleted),
+					UserRows: userRows,
+				})
+			}
+			for _, before := range deleted {
+				recordHistory(tx, id, "table", revision+1, actor, "delete", before["id"].(string), before, nil)
+			}
+
+			// 删除分组时子列提升到上一层
+			for _, child := range snapshotRows(tx, "raw_column", "parent=$1 and module_id=$2", datum.Value, id) {
+				childID := child["id"].(string)
+				tx.MustExec("update raw_column set parent=$1 where id=$2", deletedParent(deleted), childID)
+				recordHistory(tx, id, "table", revision+1, actor, "update", childID, child, snapshotRow(tx, "raw_column", childID))
+			}
 		}
 	}
+
+	if err := checkColumnGroups(tx, id); err != nil {
+		tx.Rollback()
+		db.Close()
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "1",
+			Des:  err.Error(),
+		})
+		return
+	}
+
 	tx.Commit()
 	db.Close()
 
-	res := resResultT{
-		Code: "0",
-		Des:  "",
+	setRevisionHeader(w, revision+1)
+	res := revisionResultT{
+		Code:     "0",
+		Des:      "",
+		Result:   map[string]interface{}{"removed": removed},
+		Revision: revision + 1,
 	}
 	json.NewEncoder(w).Encode(res)
 }
@@ -139,12 +271,15 @@ func (slice sliceString) search(str string) bool {
 func GetUserMaintenanceTable(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, OPTIONS")
 
 	switch r.Method {
 	case http.MethodGet:
 		getUserMaintenanceTable(w, r)
 	case http.MethodPost:
 		updateUserMaintenanceTable(w, r)
+	case http.MethodPatch:
+		patchUserMaintenanceTable(w, r)
 	}
 }
 
@@ -165,37 +300,43 @@ func updateUserMaintenanceTable(w http.ResponseWriter, r *http.Request) {
 		id = ids[0]
 	}
 
-	token := r.Header.Get("token")
+	userID, ok := tokenUserID(w, r)
+	if !ok {
+		return
+	}
 
-	if id == "" || token == "" {
+	if id == "" || userID == "" {
 		panic("id or token can not be null")
 	}
 
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		panic(err)
 	}
 
-	tx := db.MustBegin()
-	allUserValues := sliceString{}
-	err = db.Select(&allUserValues, "select value from user_column where user_id=$1", token)
-	if err != nil {
-		panic(err)
+	role := layoutRole(db, requestRoles(db, userID), "role_column", id)
+	current := make(map[string]userColumn)
+	for _, c := range userMaintenanceColumns(db, userID, role, id) {
+		current[c.Value] = c
 	}
 
-	for i, datum := range columns {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	tx := db.MustBegin()
 
+	// seq 与 raw_column 一样是列在展开后的整个列表中的位置, 分组以 raw_column 为准.
+	// 只保存与当前合并结果不同的字段, 其余字段继续使用角色和模块的设置
+	for i, datum := range flattenColumns(columns) {
 		if datum.Status == "1" {
-			if allUserValues.search(datum.Value) {
-				tx.MustExec("update user_column set hidden=$1, frozen=$2, seq=$3, location=$4, rule=$5 where module_id=$6 and user_id=$7 and value=$8", datum.Hidden, datum.Frozen, i, datum.Location, datum.Rule, id, token, datum.Value)
-			} else {
-				tx.MustExec("insert into user_column(module_id, user_id, value, hidden, frozen, seq, location, id, rule) values($1,$2,$3,$4,$5, $6, $7, $8, $9)", id, token, datum.Value, datum.Hidden, datum.Frozen, i, datum.Location, xid.New().String(), datum.Rule)
+			fields := changedColumnFields(current[datum.Value], datum, i, sliceString{"hidden", "frozen", "seq", "location", "rule"})
+			if len(fields) > 0 {
+				fields["updated_at"] = now
+				upsertLayoutColumn(tx, "user_column", "user_id", userID, id, datum.Value, fields)
 			}
 		} else {
-			// tx.MustExec("update user_column set seq=$1 where module_id=$2 and user_id=$3 and value=$4", i, id, token, datum.Value)
+			// tx.MustExec("update user_column set seq=$1 where module_id=$2 and user_id=$3 and value=$4", i, id, userID, datum.Value)
 		}
 		// } else {
-		// tx.MustExec("insert into user_column(module_id, user_id, value, hidden, frozen, seq, location, id, rule) values($1,$2,$3,$4,$5, $6, $7, $8, $9)", id, token, datum.Value, datum.Hidden, datum.Frozen, i, datum.Location, xid.New().String(), datum.Rule)
+		// tx.MustExec("insert into user_column(module_id, user_id, value, hidden, frozen, seq, location, id, rule) values($1,$2,$3,$4,$5, $6, $7, $8, $9)", id, userID, datum.Value, datum.Hidden, datum.Frozen, i, datum.Location, xid.New().String(), datum.Rule)
 		// }
 	}
 
@@ -210,7 +351,7 @@ func updateUserMaintenanceTable(w http.ResponseWriter, r *http.Request) {
 }
 
 func getUserMaintenanceTable(w http.ResponseWriter, r *http.Request) {
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -221,49 +362,18 @@ func getUserMaintenanceTable(w http.ResponseWriter, r *http.Request) {
 		id = ids[0]
 	}
 
-	token := r.Header.Get("token")
+	userID, ok := tokenUserID(w, r)
+	if !ok {
+		db.Close()
+		return
+	}
 
-	if id == "" || token == "" {
+	if id == "" || userID == "" {
 		panic("id or token is nil")
 	}
 
-	columns := []userColumn{}
-	err = db.Select(&columns, `
-						select name, fixed, raw_column.value, 
-						case when width is null
-						then '' else width
-						end as width,
-						case when user_column.seq is null
-                        then raw_column.seq else user_column.seq
-                        end as seq,
-                        case when hidden is null
-                        then '0' else hidden
-                        end as hidden,
-                        case when frozen is null
-                        then '' else frozen
-                        end as frozen, 
-                        case when user_column.id is null
-                        then raw_column.id else user_column.id
-                        end as id,
-						case when user_column.location is null
-						then raw_column.location  else user_column.location
-						end as location,
-						case when user_column.rule is null
-						then raw_column.rule else user_column.rule
-						end as rule
-                        from raw_column left join (
-							select hidden, frozen, location, id, rule, width,
-							user_id, value, module_id, seq from user_column where user_id=$1
-                        ) as user_column
-                        on raw_column.module_id=user_column.module_id 
-                        and raw_column.value=user_column.value 
-						where raw_column.module_id=$2
-						order by seq asc
-						`, token, id)
-
-	if err != nil {
-		panic(err)
-	}
+	role := layoutRole(db, requestRoles(db, userID), "role_column", id)
+	columns := columnTree(userMaintenanceColumns(db, userID, role, id))
 
 	db.Close()
 
@@ -275,45 +385,133 @@ func getUserMaintenanceTable(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
-// ResetUserMaintenanceTable 重置用户表数据
-func ResetUserMaintenanceTable(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "*")
+// userMaintenanceColumns 按 用户 -> 角色 -> 模块 的顺序合并设置, 按 seq 排序的平铺列表
+func userMaintenanceColumns(db *sqlx.DB, userID, role, id string) []userColumn {
+	columns := []userColumn{}
+	err := db.Select(&columns, `
+						select name, fixed, raw_column.value, raw_column.parent,
+						coalesce(raw_column.min_width, 0) as min_width,
+						coalesce(raw_column.max_width, 0) as max_width,
+						case when nullif(user_column.width, '') is not null then user_column.width
+						when nullif(role_column.width, '') is not null then role_column.width
+						else '' end as width,
+						case when user_column.seq is not null then user_column.seq
+						when role_column.seq is not null then role_column.seq
+						else raw_column.seq end as seq,
+						case when user_column.hidden is not null then user_column.hidden
+						when role_column.hidden is not null then role_column.hidden
+						else '0' end as hidden,
+						case when user_column.frozen is not null then user_column.frozen
+						when role_column.frozen is not null then role_column.frozen
+						else '' end as frozen,
+						case when user_column.id is not null then user_column.id
+						when role_column.id is not null then role_column.id
+						else raw_column.id end as id,
+						case when user_column.location is not null then user_column.location
+						when role_column.location is not null then role_column.location
+						else raw_column.location end as location,
+						case when user_column.rule is not null then user_column.rule
+						when role_column.rule is not null then role_column.rule
+						else raw_column.rule end as rule
+						from raw_column left join (
+							select hidden, frozen, location, id, rule, width,
+							value, module_id, seq from role_column where role=$1
+						) as role_column
+						on raw_column.module_id=role_column.module_id
+						and raw_column.value=role_column.value
+						left join (
+							select hidden, frozen, location, id, rule, width,
+							user_id, value, module_id, seq from user_column where user_id=$2 and user_id!=''
+						) as user_column
+						on raw_column.module_id=user_column.module_id
+						and raw_column.value=user_column.value
+						where raw_column.module_id=$3
+						order by seq asc
+						`, role, userID, id)
 
-	db, err := sqlx.Connect("sqlite3", "_db.db")
 	if err != nil {
-		log.Fatalln(err)
+		panic(err)
 	}
+	return columns
+}
 
-	ids := r.URL.Query()["id"]
-	var id string
-	if len(ids) >= 1 {
-		id = ids[0]
+// changedColumnFields datum 中与当前合并结果不同的字段, 只有这些字段写入用户或角色层
+func changedColumnFields(current, datum userColumn, seq int, fields sliceString) map[string]interface{} {
+	flag := func(s string) string {
+		if s == "1" {
+			return "1"
+		}
+		return "0"
 	}
 
-	token := r.Header.Get("token")
+	changed := make(map[string]interface{})
+	if fields.search("hidden") && flag(datum.Hidden) != flag(current.Hidden) {
+		changed["hidden"] = flag(datum.Hidden)
+	}
+	if fields.search("frozen") && flag(datum.Frozen) != flag(current.Frozen) {
+		changed["frozen"] = flag(datum.Frozen)
+	}
+	if fields.search("seq") && strconv.Itoa(seq) != current.Seq {
+		changed["seq"] = seq
+	}
+	if fields.search("location") && datum.Location != current.Location {
+		changed["location"] = datum.Location
+	}
+	if fields.search("rule") && datum.Rule != current.Rule {
+		changed["rule"] = datum.Rule
+	}
+	if fields.search("width") && datum.Width != "" && datum.Width != current.Width {
+		changed["width"] = datum.Width
+	}
+	return changed
+}
 
-	if id == "" || token == "" {
-		panic("id or token is nil")
+// upsertLayoutColumn 按 module_id, owner, value 更新 user_column 或 role_column 中的指定字段.
+// 新建的行只写入这些字段, 其余为 NULL, 合并时回退到下一层的设置
+func upsertLayoutColumn(tx *sqlx.Tx, table, ownerField, owner, id, value string, fields map[string]interface{}) {
+	names := []string{}
+	for name := range fields {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	tx := db.MustBegin()
-	tx.MustExec("delete from user_column where user_id=$1 and module_id=$2", token, id)
-	tx.Commit()
-	db.Close()
-	res := resResultT{
-		Code: "0",
-		Des:  "",
+	var count int
+	err := tx.Get(&count, "select count(*) from "+table+" where module_id=$1 and "+ownerField+"=$2 and value=$3", id, owner, value)
+	if err != nil {
+		panic(err)
 	}
-	json.NewEncoder(w).Encode(res)
+
+	args := []interface{}{}
+	if count > 0 {
+		sets := []string{}
+		for _, name := range names {
+			args = append(args, fields[name])
+			sets = append(sets, fmt.Sprintf("%s=$%d", name, len(args)))
+		}
+		args = append(args, id, owner, value)
+		tx.MustExec(fmt.Sprintf("update %s set %s where module_id=$%d and %s=$%d and value=$%d",
+			table, strings.Join(sets, ", "), len(args)-2, ownerField, len(args)-1, len(args)), args...)
+		return
+	}
+
+	columns := append([]string{"id", "module_id", ownerField, "value"}, names...)
+	args = append(args, xid.New().String(), id, owner, value)
+	for _, name := range names {
+		args = append(args, fields[name])
+	}
+	placeholders := []string{}
+	for i := range columns {
+		placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
+	}
+	tx.MustExec("insert into "+table+"("+strings.Join(columns, ", ")+") values("+strings.Join(placeholders, ", ")+")", args...)
 }
 
-// OverrideUserMaintenanceTable 重置用户表数据
-func OverrideUserMaintenanceTable(w http.ResponseWriter, r *http.Request) {
+// ResetUserMaintenanceTable 重置用户表数据
+func ResetUserMaintenanceTable(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
-	
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -324,37 +522,19 @@ func OverrideUserMaintenanceTable(w http.ResponseWriter, r *http.Request) {
 		id = ids[0]
 	}
 
-	if id == "" {
-		panic("id can not be null")
-	}
-
-	decoder := json.NewDecoder(r.Body)
-	columns := make(map[string][]string)
-	err = decoder.Decode(&columns)
-	if err != nil {
-		panic(err)
+	userID, ok := tokenUserID(w, r)
+	if !ok {
+		db.Close()
+		return
 	}
 
-	allUserTableFields := sliceString{
-		"hidden", "frozen", "location", "width",
+	if id == "" || userID == "" {
+		panic("id or token is nil")
 	}
 
 	tx := db.MustBegin()
-	for k, v := range columns {
-		sqlStr := "update user_column set "
-		for _, filed := range v {
-			if allUserTableFields.search(filed) {
-				sqlStr = sqlStr + filed + "=null, "
-			}
-		}
-		if strings.HasSuffix(sqlStr, ", ") {
-			newSQLStr := strings.TrimSuffix(sqlStr, ", ")
-			newSQLStr += " where value=$1 and module_id=$2"
-			tx.MustExec(newSQLStr, k, id)
-		}
-	}
+	tx.MustExec("delete from user_column where user_id=$1 and module_id=$2", userID, id)
 	tx.Commit()
-
 	db.Close()
 	res := resResultT{
 		Code: "0",
@@ -363,19 +543,33 @@ func OverrideUserMaintenanceTable(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
-//UpdateUserMaintenanceTableWidth 设置表格宽度
+// OverrideUserMaintenanceTable 重置用户表数据
+func OverrideUserMaintenanceTable(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	overrideUserLayout(w, r, "user_column", "table", sliceString{
+		"hidden", "frozen", "location", "width",
+	})
+}
+
+// UpdateUserMaintenanceTableWidth 设置表格宽度
 func UpdateUserMaintenanceTableWidth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
 
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	token := r.Header.Get("token")
+	userID, ok := tokenUserID(w, r)
+	if !ok {
+		db.Close()
+		return
+	}
 
-	if token == "" {
+	if userID == "" {
 		panic("token is nil")
 	}
 
@@ -390,30 +584,28 @@ func UpdateUserMaintenanceTableWidth(w http.ResponseWriter, r *http.Request) {
 	id := param["id"]
 	value := param["value"]
 
-	res := resResultT{
-		Code: "0",
-		Des:  "",
-	}
-
 	if width == "" || value == "" || id == "" {
-		json.NewEncoder(w).Encode(res)
+		db.Close()
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "1",
+			Des:  "缺少 id, value 或 width",
+		})
 		return
 	}
 
-	tx := db.MustBegin()
-	var dataExist int
-	err = db.Get(&dataExist, "select count(*) from user_column where module_id=$1 and user_id=$2 and value=$3", id, token, value)
-	if err != nil {
-		panic(err)
-	}
-	if dataExist == 0 {
-		tx.MustExec("insert into user_column(module_id, user_id, value, width, id) values($1,$2,$3,$4,$5)", id, token, value, width, xid.New().String())
-	} else {
-		tx.MustExec("update user_column set width=$1 where module_id=$2 and user_id=$3 and value=$4", width, id, token, value)
-	}
+	saved, rejected := saveColumnWidths(db, userID, id, []columnWidthT{{Value: value, Width: width}})
 
-	tx.Commit()
 	db.Close()
 
+	res := resResultT{
+		Code: "0",
+		Des:  "",
+	}
+	if len(rejected) > 0 {
+		res.Code = "1"
+		res.Des = rejected[0].Error
+	} else {
+		res.Result = saved[0]
+	}
 	json.NewEncoder(w).Encode(res)
 }
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 市场 (租户) 由请求头 tenant 或 token 中的 tenant 选择, 都没有时使用 tenants.json 中的第一个.
+// 默认市场使用 _db.db 和根目录下的数据文件, 其它市场使用 _db.<tenant>.db,
+// 数据文件优先读取 tenant/<tenant>/ 下的同名文件
+
+type tenantT struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Company string `json:"company"`
+}
+
+type tenantKey struct{}
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+func readTenants() []tenantT {
+	plan, err := ioutil.ReadFile("tenants.json")
+	if os.IsNotExist(err) {
+		return []tenantT{{}}
+	} else if err != nil {
+		panic(err)
+	}
+
+	tenants := []tenantT{}
+	err = json.Unmarshal(plan, &tenants)
+	if err != nil {
+		panic(err)
+	}
+	if len(tenants) == 0 {
+		return []tenantT{{}}
+	}
+	return tenants
+}
+
+func findTenant(id string) (tenantT, bool) {
+	for _, t := range readTenants() {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return tenantT{}, false
+}
+
+func defaultTenant() tenantT {
+	return readTenants()[0]
+}
+
+// requestTenant 当前请求的市场
+func requestTenant(r *http.Request) tenantT {
+	if t, ok := r.Context().Value(tenantKey{}).(tenantT); ok {
+		return t
+	}
+	return defaultTenant()
+}
+
+func tenantDBFile(tenant string) string {
+	if tenant == "" || tenant == defaultTenant().ID {
+		return "_db.db"
+	}
+	return "_db." + tenant + ".db"
+}
+
+// tenantDB 当前请求的市场使用的数据库文件
+func tenantDB(r *http.Request) string {
+	return tenantDBFile(requestTenant(r).ID)
+}
+
+// tenantFile 市场自己的数据文件, 没有时使用根目录下的
+func tenantFile(tenant, name string) string {
+	if tenant == "" || tenant == defaultTenant().ID {
+		return name
+	}
+	file := filepath.Join("tenant", tenant, name)
+	if _, err := os.Stat(file); err == nil {
+		return file
+	}
+	return name
+}
+
+var tenantDBLock sync.Mutex
+
+// ensureTenantDB 市场的数据库不存在时按 _db.db 的表结构创建, 并复制模块定义和初始数据
+func ensureTenantDB(tenant string) {
+	file := tenantDBFile(tenant)
+	if file == "_db.db" {
+		return
+	}
+
+	tenantDBLock.Lock()
+	defer tenantDBLock.Unlock()
+
+	if _, err := os.Stat(file); err == nil {
+		return
+	}
+
+	base, err := sqlx.Connect("sqlite3", "_db.db")
+	if err != nil {
+		panic(err)
+	}
+	// 全文索引的影子表由虚拟表自动创建, 不能复制
+	statements := []string{}
+	err = base.Select(&statements, `
+						select sql from sqlite_master m
+						where sql is not null and name not like 'sqlite_%'
+						and not exists (
+							select 1 from sqlite_master v
+							where v.sql like 'CREATE VIRTUAL TABLE%' and m.type='table' and m.name like v.name || '\_%' escape '\'
+						)
+						order by type='table' desc
+						`)
+	base.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	db, err := sqlx.Connect("sqlite3", file)
+	if err != nil {
+		panic(err)
+	}
+	tx := db.MustBegin()
+	for _, statement := range statements {
+		tx.MustExec(statement)
+	}
+	tx.Commit()
+	db.Close()
+
+	log.Printf("tenant %s database created\n", tenant)
+	runMigrations(file)
+	copyTenantSeed(file)
+}
+
+// tenantSeedTables 新市场从默认市场复制的表: 模块定义和初始数据, 不包括用户的个性化设置
+var tenantSeedTables = []string{"raw_column", "raw_filter", "module_endpoint", "column", "person"}
+
+// copyTenantSeed 把默认市场的模块定义和初始数据复制到市场数据库, 每个数据库只执行一次, 已有数据的表不复制
+func copyTenantSeed(file string) {
+	const name = "copy-tenant-seed"
+
+	db, err := sqlx.Connect("sqlite3", file)
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	// attach 只对当前连接有效
+	db.SetMaxOpenConns(1)
+
+	var count int
+	err = db.Get(&count, "select count(*) from schema_migration where name=$1", name)
+	if err != nil {
+		panic(err)
+	}
+	if count > 0 {
+		return
+	}
+
+	db.MustExec("attach database '_db.db' as base")
+	defer db.Exec("detach database base")
+
+	tx := db.MustBegin()
+	for _, table := range tenantSeedTables {
+		columns := []string{}
+		err = tx.Select(&columns, "select '`' || name || '`' from pragma_table_info($1) where name in (select name from base.pragma_table_info($1))", table)
+		if err != nil {
+			panic(err)
+		}
+		if len(columns) == 0 {
+			continue
+		}
+
+		err = tx.Get(&count, "select count(*) from main.`"+table+"`")
+		if err != nil {
+			panic(err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		list := strings.Join(columns, ", ")
+		result := tx.MustExec("insert into main.`" + table + "`(" + list + ") select " + list + " from base.`" + table + "`")
+		n, err := result.RowsAffected()
+		if err != nil {
+			panic(err)
+		}
+		log.Printf("%s: %d rows copied to %s\n", table, n, file)
+	}
+	tx.MustExec("insert into schema_migration(name, applied_at) values($1, $2)", name, time.Now().Format("2006-01-02 15:04:05"))
+	tx.Commit()
+}
+
+// migrateTenantDBs 启动时对已经创建的市场数据库执行新增的迁移
+func migrateTenantDBs() {
+	for _, t := range readTenants() {
+		file := tenantDBFile(t.ID)
+		if file == "_db.db" {
+			continue
+		}
+		if _, err := os.Stat(file); err == nil {
+			runMigrations(file)
+			copyTenantSeed(file)
+		}
+	}
+}
+
+// tenantScope 选择请求的市场, 请求头和 token 中的市场不一致时返回 403
+func tenantScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := r.Header.Get("tenant")
+
+		// 没有 tenant 的旧 token 属于默认市场
+		claim := ""
+		token := r.Header.Get("token")
+		if token == "" {
+			token = r.FormValue("token")
+		}
+		if claims, err := verifyToken(token); err == nil {
+			claim = claims.Tenant
+			if claim == "" {
+				claim = defaultTenant().ID
+			}
+		}
+
+		id := header
+		if id == "" {
+			id = claim
+		}
+		if id == "" {
+			id = defaultTenant().ID
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "*")
+
+		if header != "" && claim != "" && header != claim {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(resResultT{
+				Code: "403",
+				Des:  fmt.Sprintf("token 属于市场 %s, 不能访问市场 %s", claim, header),
+			})
+			return
+		}
+
+		tenant, ok := findTenant(id)
+		if !ok || (id != "" && !tenantIDPattern.MatchString(id)) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(resResultT{
+				Code: "400",
+				Des:  fmt.Sprintf("市场 %s 不存在", id),
+			})
+			return
+		}
+
+		ensureTenantDB(tenant.ID)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)))
+	})
+}
+
+// Tenants 可以选择的市场
+func Tenants(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	json.NewEncoder(w).Encode(resResultT{
+		Code: "0",
+		Des:  "",
+		Result: map[string]interface{}{
+			"current": requestTenant(r),
+			"tenants": readTenants(),
+		},
+	})
+}
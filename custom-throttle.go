@@ -0,0 +1,385 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/xid"
+)
+
+// 登录失败分别按用户名和客户端 IP 计数: login_attempt 中 ip 为空的行是用户名的计数,
+// username 为空的行是 IP 的计数. 任意一个达到 LOGIN_CAPTCHA_AFTER 次后需要验证码,
+// 用户名的计数达到 LOGIN_LOCK_AFTER 次后锁定账号 LOGIN_LOCK_MINUTES 分钟.
+// 超过 LOGIN_LOCK_MINUTES 没有再失败时计数清零.
+// 只有请求来自 TRUSTED_PROXIES (逗号分隔的 IP 或 CIDR) 时才使用 X-Forwarded-For
+
+// 登录失败的错误码
+const (
+	loginCodeBadCredential   = "1"
+	loginCodeCaptchaRequired = "2"
+	loginCodeLocked          = "3"
+	loginCodeCaptchaWrong    = "4"
+)
+
+const captchaTTL = 5 * time.Minute
+
+func envInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+var (
+	captchaAfter = envInt("LOGIN_CAPTCHA_AFTER", 3)
+	lockAfter    = envInt("LOGIN_LOCK_AFTER", 5)
+	lockDuration = time.Duration(envInt("LOGIN_LOCK_MINUTES", 15)) * time.Minute
+)
+
+var trustedProxies = func() []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, item := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			if strings.Contains(item, ":") {
+				item += "/128"
+			} else {
+				item += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			panic(fmt.Sprintf("TRUSTED_PROXIES: %v", err))
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+func trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+type loginAttemptT struct {
+	Username    string `db:"username" json:"username"`
+	IP          string `db:"ip" json:"ip"`
+	Failures    int    `db:"failures" json:"failures"`
+	LockedUntil int64  `db:"locked_until" json:"lockedUntil"`
+	Updated     string `db:"updated_at" json:"updatedAt"`
+}
+
+type loginErrorT struct {
+	Code   string
+	Des    string
+	Detail map[string]interface{}
+}
+
+// clientIP 直连地址是可信代理时, 从 X-Forwarded-For 的最右边跳过可信代理, 取第一个地址
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !trustedProxy(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+// checkCaptcha 校验并作废验证码
+func checkCaptcha(db *sqlx.DB, id, answer string) bool {
+	var expected string
+	err := db.Get(&expected, "select answer from captcha where id=$1 and expires_at>$2", id, time.Now().Unix())
+	if err == sql.ErrNoRows {
+		return false
+	} else if err != nil {
+		panic(err)
+	}
+	db.MustExec("delete from captcha where id=$1", id)
+	return strings.EqualFold(expected, strings.TrimSpace(answer))
+}
+
+// loginCounter 读取计数, 没有锁定且超过 lockDuration 没有失败的计数视为清零
+func loginCounter(db *sqlx.DB, username, ip string, now time.Time) loginAttemptT {
+	attempt := loginAttemptT{Username: username, IP: ip}
+	err := db.Get(&attempt, "select username, ip, failures, locked_until, updated_at from login_attempt where username=$1 and ip=$2", username, ip)
+	if err == sql.ErrNoRows {
+		return attempt
+	} else if err != nil {
+		panic(err)
+	}
+
+	// 锁定到期后重新计数
+	if attempt.LockedUntil > 0 && attempt.LockedUntil <= now.Unix() {
+		attempt.Failures = 0
+		attempt.LockedUntil = 0
+	}
+	if updated, err := time.ParseInLocation("2006-01-02 15:04:05", attempt.Updated, time.Local); attempt.LockedUntil == 0 && err == nil && now.Sub(updated) > lockDuration {
+		attempt.Failures = 0
+	}
+	return attempt
+}
+
+func saveLoginCounter(db *sqlx.DB, attempt loginAttemptT, now time.Time) {
+	db.MustExec("insert or replace into login_attempt(username, ip, failures, locked_until, updated_at) values($1, $2, $3, $4, $5)",
+		attempt.Username, attempt.IP, attempt.Failures, attempt.LockedUntil, now.Format("2006-01-02 15:04:05"))
+}
+
+// authenticate 在失败次数限制下校验用户名密码
+func authenticate(db *sqlx.DB, r *http.Request, cred credentialT) (string, *loginErrorT) {
+	now := time.Now()
+	account := loginCounter(db, cred.Username, "", now)
+	client := loginCounter(db, "", clientIP(r), now)
+
+	if account.LockedUntil > now.Unix() {
+		return "", &loginErrorT{
+			Code:   loginCodeLocked,
+			Des:    fmt.Sprintf("登录失败次数过多, 账号已锁定, 请在 %s 后重试", time.Unix(account.LockedUntil, 0).Format("15:04:05")),
+			Detail: map[string]interface{}{"lockedUntil": account.LockedUntil},
+		}
+	}
+
+	captchaRequired := account.Failures >= captchaAfter || client.Failures >= captchaAfter
+
+	fail := func(code, des string) (string, *loginErrorT) {
+		account.Failures++
+		client.Failures++
+		detail := map[string]interface{}{
+			"failures":        account.Failures,
+			"captchaRequired": account.Failures >= captchaAfter || client.Failures >= captchaAfter,
+		}
+		if account.Failures >= lockAfter {
+			account.LockedUntil = now.Add(lockDuration).Unix()
+			code = loginCodeLocked
+			des = fmt.Sprintf("登录失败次数过多, 账号已锁定 %d 分钟", int(lockDuration.Minutes()))
+			detail["lockedUntil"] = account.LockedUntil
+		} else {
+			detail["remaining"] = lockAfter - account.Failures
+		}
+		saveLoginCounter(db, account, now)
+		saveLoginCounter(db, client, now)
+		return "", &loginErrorT{Code: code, Des: des, Detail: detail}
+	}
+
+	if captchaRequired {
+		if cred.CaptchaID == "" || cred.Captcha == "" {
+			return "", &loginErrorT{
+				Code:   loginCodeCaptchaRequired,
+				Des:    "请输入验证码",
+				Detail: map[string]interface{}{"failures": account.Failures, "captchaRequired": true},
+			}
+		}
+		if !checkCaptcha(db, cred.CaptchaID, cred.Captcha) {
+			return fail(loginCodeCaptchaWrong, "验证码错误")
+		}
+	}
+
+	userID, err := checkPassword(db, cred.Username, cred.Password)
+	if err != nil {
+		return fail(loginCodeBadCredential, err.Error())
+	}
+
+	// IP 的计数不因某个账号登录成功而清零, 避免用自己的账号重置对其他账号的尝试
+	db.MustExec("delete from login_attempt where username=$1 and ip=''", cred.Username)
+	return userID, nil
+}
+
+// 3x5 点阵数字
+var captchaFont = map[byte][5]string{
+	'0': {"111", "101", "101", "101", "111"},
+	'1': {"010", "110", "010", "010", "111"},
+	'2': {"111", "001", "111", "100", "111"},
+	'3': {"111", "001", "111", "001", "111"},
+	'4': {"101", "101", "111", "001", "001"},
+	'5': {"111", "100", "111", "001", "111"},
+	'6': {"111", "100", "111", "101", "111"},
+	'7': {"111", "001", "010", "010", "010"},
+	'8': {"111", "101", "111", "101", "111"},
+	'9': {"111", "101", "111", "001", "111"},
+}
+
+func captchaImage(answer string, rd *rand.Rand) image.Image {
+	const scale = 6
+	width := len(answer)*4*scale + 2*scale
+	height := 7 * scale
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{240, 240, 240, 255})
+		}
+	}
+
+	for i := 0; i < len(answer); i++ {
+		ink := color.RGBA{uint8(rd.Intn(120)), uint8(rd.Intn(120)), uint8(rd.Intn(120)), 255}
+		offsetX := scale + i*4*scale
+		offsetY := scale + rd.Intn(scale) - scale/2
+		for row, line := range captchaFont[answer[i]] {
+			for col, dot := range line {
+				if dot != '1' {
+					continue
+				}
+				for dx := 0; dx < scale; dx++ {
+					for dy := 0; dy < scale; dy++ {
+						img.Set(offsetX+col*scale+dx, offsetY+row*scale+dy, ink)
+					}
+				}
+			}
+		}
+	}
+
+	// 干扰点
+	for i := 0; i < width*height/8; i++ {
+		img.Set(rd.Intn(width), rd.Intn(height), color.RGBA{uint8(rd.Intn(256)), uint8(rd.Intn(256)), uint8(rd.Intn(256)), 255})
+	}
+	return img
+}
+
+// Captcha 生成验证码图片, id 在响应头 Captcha-Id 中
+func Captcha(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "Captcha-Id")
+
+	rd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	answer := fmt.Sprintf("%04d", rd.Intn(10000))
+	id := xid.New().String()
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	db.MustExec("delete from captcha where expires_at<=$1", time.Now().Unix())
+	db.MustExec("insert into captcha(id, answer, expires_at) values($1, $2, $3)", id, answer, time.Now().Add(captchaTTL).Unix())
+
+	db.Close()
+
+	w.Header().Set("Captcha-Id", id)
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	png.Encode(w, captchaImage(answer, rd))
+}
+
+// CaptchaAnswer 验证码的答案, 供自动化测试使用
+func CaptchaAnswer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	if id == "" {
+		panic("id can not be null")
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	var answer string
+	err = db.Get(&answer, "select answer from captcha where id=$1 and expires_at>$2", id, time.Now().Unix())
+
+	db.Close()
+
+	if err == sql.ErrNoRows {
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "1",
+			Des:  "验证码不存在或已过期",
+		})
+		return
+	} else if err != nil {
+		panic(err)
+	}
+
+	json.NewEncoder(w).Encode(resResultT{
+		Code:   "0",
+		Des:    "",
+		Result: map[string]string{"id": id, "answer": answer},
+	})
+}
+
+// LoginAttempts 登录失败记录, DELETE 时按 username 解锁账号或按 ip 清除 IP 的计数
+func LoginAttempts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, DELETE, OPTIONS")
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	username := r.URL.Query().Get("username")
+	ip := r.URL.Query().Get("ip")
+
+	switch r.Method {
+	case http.MethodGet:
+		attempts := []loginAttemptT{}
+		err = db.Select(&attempts, "select username, ip, failures, locked_until, updated_at from login_attempt where $1='' or username=$1 order by updated_at desc", username)
+		if err != nil {
+			panic(err)
+		}
+		json.NewEncoder(w).Encode(resResultT{
+			Code:   "0",
+			Des:    "",
+			Result: attempts,
+		})
+	case http.MethodDelete:
+		if username == "" && ip == "" {
+			panic("username or ip can not be null")
+		}
+		if username != "" {
+			db.MustExec("delete from login_attempt where username=$1 and ip=''", username)
+		}
+		if ip != "" {
+			db.MustExec("delete from login_attempt where username='' and ip=$1", ip)
+		}
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "0",
+			Des:  "",
+		})
+	}
+
+	db.Close()
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// jwtSecret HS256 签名密钥, 通过环境变量 JWT_SECRET 配置
+var jwtSecret = func() []byte {
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	return []byte("go-mock-secret")
+}()
+
+// 旧版客户端的 token 没有 exp, 过期时间在 ext 中, ext 和 iat 都是毫秒
+type tokenClaimsT struct {
+	UserID string   `json:"userId"`
+	Roles  []string `json:"roles,omitempty"`
+	Exp    int64    `json:"exp"`
+	Ext    int64    `json:"ext,omitempty"`
+	Iat    int64    `json:"iat"`
+	Jti    string   `json:"jti,omitempty"`
+	Tenant string   `json:"tenant,omitempty"`
+}
+
+// legacyMillis 超过这个值的时间认为是毫秒
+const legacyMillis = 1e12
+
+// stripBearer 去掉 "bearer;" 或 "Bearer " 前缀
+func stripBearer(token string) string {
+	token = strings.TrimSpace(token)
+	for _, prefix := range []string{"bearer;", "bearer "} {
+		if len(token) >= len(prefix) && strings.EqualFold(token[:len(prefix)], prefix) {
+			return strings.TrimSpace(token[len(prefix):])
+		}
+	}
+	return token
+}
+
+// decodeTokenClaims 只解码 payload, 不校验签名, 用于迁移旧数据
+func decodeTokenClaims(token string) (tokenClaimsT, error) {
+	claims := tokenClaimsT{}
+	parts := strings.Split(stripBearer(token), ".")
+	if len(parts) != 3 {
+		return claims, errors.New("token 格式错误")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, errors.New("token payload 格式错误")
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return claims, errors.New("token payload 格式错误")
+	}
+	return claims, nil
+}
+
+// signToken 生成 HS256 签名的 token
+func signToken(claims tokenClaimsT) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"HS256"}`))
+	b, err := json.Marshal(claims)
+	if err != nil {
+		panic(err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte(header + "." + payload))
+	return header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseToken 校验 HS256 签名, 过期时间和是否已注销
+func parseToken(token string) (tokenClaimsT, error) {
+	claims, err := verifyToken(token)
+	if err != nil {
+		return claims, err
+	}
+	if time.Now().Unix() >= claims.Exp {
+		return claims, errors.New("token 已过期")
+	}
+	return claims, nil
+}
+
+// verifyToken 只校验签名和是否已注销, 不检查过期, 刷新 token 时使用
+func verifyToken(token string) (tokenClaimsT, error) {
+	token = stripBearer(token)
+	claims, err := decodeTokenClaims(token)
+	if err != nil {
+		return claims, err
+	}
+
+	parts := strings.Split(token, ".")
+	header := struct {
+		Alg string `json:"alg"`
+	}{}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(b, &header) != nil {
+		return claims, errors.New("token header 格式错误")
+	}
+	if header.Alg != "HS256" {
+		return claims, fmt.Errorf("不支持的签名算法 %s", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, errors.New("token 签名格式错误")
+	}
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return claims, errors.New("token 签名错误")
+	}
+
+	if claims.Exp == 0 && claims.Ext > 0 {
+		claims.Exp = claims.Ext
+	}
+	if claims.Exp > legacyMillis {
+		claims.Exp /= 1000
+	}
+	if claims.Iat > legacyMillis {
+		claims.Iat /= 1000
+	}
+	if claims.Exp == 0 {
+		return claims, errors.New("token 中没有 exp")
+	}
+	if claims.UserID == "" {
+		return claims, errors.New("token 中没有 userId")
+	}
+	if claims.Jti != "" && tokenRevoked(claims.Jti) {
+		return claims, errors.New("token 已注销")
+	}
+	return claims, nil
+}
+
+// tokenUserID 从 token 头中取出 userId, 校验失败时返回 401
+func tokenUserID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token := r.Header.Get("token")
+	if token == "" {
+		writeUnauthorized(w, "缺少 token")
+		return "", false
+	}
+
+	claims, err := parseToken(token)
+	if err != nil {
+		writeUnauthorized(w, err.Error())
+		return "", false
+	}
+	return claims.UserID, true
+}
+
+func writeUnauthorized(w http.ResponseWriter, des string) {
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(resResultT{
+		Code: "401",
+		Des:  des,
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rawToken 用给定的 header 和 payload 生成 token, secret 为空时使用 jwtSecret
+func rawToken(header, payload string, secret []byte) string {
+	if secret == nil {
+		secret = jwtSecret
+	}
+	h := base64.RawURLEncoding.EncodeToString([]byte(header))
+	p := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(h + "." + p))
+	return h + "." + p + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseToken(t *testing.T) {
+	now := time.Now()
+	hs256 := `{"typ":"JWT","alg":"HS256"}`
+	exp := now.Add(time.Hour).Unix()
+	valid := signToken(tokenClaimsT{UserID: "0013", Exp: exp, Iat: now.Unix()})
+
+	tampered := strings.Split(valid, ".")
+	tampered[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"userId":"0001","exp":9999999999}`))
+
+	cases := []struct {
+		name   string
+		token  string
+		userID string
+		err    string
+	}{
+		{"valid", valid, "0013", ""},
+		{"bearer prefix", "bearer;" + valid, "0013", ""},
+		{"legacy ext in milliseconds", rawToken(hs256, `{"userId":"0013","ext":`+itoa(now.Add(time.Hour).UnixNano()/1e6)+`,"iat":`+itoa(now.UnixNano()/1e6)+`}`, nil), "0013", ""},
+		{"bad signature", rawToken(hs256, `{"userId":"0013","exp":`+itoa(exp)+`}`, []byte("other-secret")), "", "token 签名错误"},
+		{"tampered payload", strings.Join(tampered, "."), "", "token 签名错误"},
+		{"alg none", rawToken(`{"typ":"JWT","alg":"none"}`, `{"userId":"0013","exp":`+itoa(exp)+`}`, nil), "", "不支持的签名算法 none"},
+		{"alg HS512", rawToken(`{"typ":"JWT","alg":"HS512"}`, `{"userId":"0013","exp":`+itoa(exp)+`}`, nil), "", "不支持的签名算法 HS512"},
+		{"expired", rawToken(hs256, `{"userId":"0013","exp":`+itoa(now.Add(-time.Minute).Unix())+`}`, nil), "", "token 已过期"},
+		{"legacy ext expired", rawToken(hs256, `{"userId":"0013","ext":`+itoa(now.Add(-time.Minute).UnixNano()/1e6)+`}`, nil), "", "token 已过期"},
+		{"missing exp", rawToken(hs256, `{"userId":"0013"}`, nil), "", "token 中没有 exp"},
+		{"missing userId", rawToken(hs256, `{"exp":`+itoa(exp)+`}`, nil), "", "token 中没有 userId"},
+		{"not a jwt", "abc", "", "token 格式错误"},
+		{"bad header", "!!." + strings.SplitN(valid, ".", 2)[1], "", "token header 格式错误"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			claims, err := parseToken(c.token)
+			if c.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if claims.UserID != c.userID {
+					t.Fatalf("userId = %q, want %q", claims.UserID, c.userID)
+				}
+				if claims.Exp > legacyMillis {
+					t.Fatalf("exp %d not in seconds", claims.Exp)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error %q, got none", c.err)
+			}
+			if err.Error() != c.err {
+				t.Fatalf("error = %q, want %q", err.Error(), c.err)
+			}
+		})
+	}
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// raw_column 上的 min_width / max_width 为 0 时表示不限制
+
+func checkWidthRange(minWidth, maxWidth int) error {
+	if minWidth < 0 || maxWidth < 0 {
+		return errors.New("最小宽度和最大宽度不能为负数")
+	}
+	if maxWidth > 0 && minWidth > maxWidth {
+		return fmt.Errorf("最小宽度 %d 大于最大宽度 %d", minWidth, maxWidth)
+	}
+	return nil
+}
+
+func clampWidth(width, minWidth, maxWidth int) int {
+	if minWidth > 0 && width < minWidth {
+		return minWidth
+	}
+	if maxWidth > 0 && width > maxWidth {
+		return maxWidth
+	}
+	return width
+}
+
+// columnWidthT width 可以是数字或字符串, 格式错误的在结果中拒绝
+type columnWidthT struct {
+	Value string      `json:"value"`
+	Width interface{} `json:"width"`
+}
+
+type savedWidthT struct {
+	Value     string `json:"value"`
+	Width     int    `json:"width"`
+	Requested int    `json:"requested"`
+	Clamped   bool   `json:"clamped"`
+}
+
+type rejectedWidthT struct {
+	Value string `json:"value"`
+	Width string `json:"width"`
+	Error string `json:"error"`
+}
+
+// saveColumnWidths 在一个事务中保存多列宽度, 超出范围的按范围截断, 无效的拒绝
+func saveColumnWidths(db *sqlx.DB, userID, id string, widths []columnWidthT) ([]savedWidthT, []rejectedWidthT) {
+	columns := make(map[string]rawColumn)
+	for _, c := range maintenanceColumns(db, id) {
+		columns[c.Value] = c
+	}
+
+	saved := []savedWidthT{}
+	rejected := []rejectedWidthT{}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	tx := db.MustBegin()
+	for _, datum := range widths {
+		str := ""
+		if datum.Width != nil {
+			str = fmt.Sprint(datum.Width)
+		}
+
+		c, ok := columns[datum.Value]
+		if !ok {
+			rejected = append(rejected, rejectedWidthT{
+				Value: datum.Value,
+				Width: str,
+				Error: fmt.Sprintf("列 %s 不存在", datum.Value),
+			})
+			continue
+		}
+
+		requested, err := strconv.Atoi(str)
+		if err != nil || requested <= 0 {
+			rejected = append(rejected, rejectedWidthT{
+				Value: datum.Value,
+				Width: str,
+				Error: "宽度应为正整数",
+			})
+			continue
+		}
+
+		width := clampWidth(requested, c.MinWidth, c.MaxWidth)
+
+		// 只保存宽度, 其余字段为 NULL 时继续使用角色和模块的设置
+		upsertLayoutColumn(tx, "user_column", "user_id", userID, id, datum.Value, map[string]interface{}{
+			"width":      width,
+			"updated_at": now,
+		})
+
+		saved = append(saved, savedWidthT{
+			Value:     datum.Value,
+			Width:     width,
+			Requested: requested,
+			Clamped:   width != requested,
+		})
+	}
+	tx.Commit()
+
+	return saved, rejected
+}
+
+// UpdateUserMaintenanceTableWidths 批量保存用户列宽
+func UpdateUserMaintenanceTableWidths(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+
+	if r.Method != http.MethodPost {
+		return
+	}
+
+	widths := []columnWidthT{}
+	err := json.NewDecoder(r.Body).Decode(&widths)
+	if err != nil {
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "1",
+			Des:  err.Error(),
+		})
+		return
+	}
+
+	ids := r.URL.Query()["id"]
+	id := ""
+	if len(ids) >= 1 {
+		id = ids[0]
+	}
+
+	userID, ok := tokenUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if id == "" {
+		json.NewEncoder(w).Encode(resResultT{
+			Code: "1",
+			Des:  "缺少 id",
+		})
+		return
+	}
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	saved, rejected := saveColumnWidths(db, userID, id, widths)
+
+	db.Close()
+
+	res := resResultT{
+		Code: "0",
+		Des:  "",
+		Result: map[string]interface{}{
+			"saved":    saved,
+			"rejected": rejected,
+		},
+	}
+	if len(saved) == 0 && len(rejected) > 0 {
+		res.Code = "1"
+		res.Des = "没有保存任何列宽"
+	}
+	json.NewEncoder(w).Encode(res)
+}
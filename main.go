@@ -61,6 +61,142 @@ CREATE TABLE  IF NOT EXISTS person (
 	longitude VARCHAR(80),
 	guid VARCHAR(80)
 );
+
+CREATE TABLE IF NOT EXISTS module_revision (
+	module_id VARCHAR(255),
+	target VARCHAR(10),
+	revision INTEGER DEFAULT 0,
+	PRIMARY KEY (
+		module_id,
+		target
+	)
+);
+
+CREATE TABLE IF NOT EXISTS module_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	module_id VARCHAR(255),
+	target VARCHAR(10),
+	revision INTEGER,
+	row_id VARCHAR(255),
+	action VARCHAR(10),
+	actor VARCHAR(255),
+	created_at VARCHAR(20),
+	before_value TEXT,
+	after_value TEXT,
+	row_table VARCHAR(40)
+);
+
+CREATE TABLE IF NOT EXISTS user_role (
+	user_id VARCHAR(255),
+	role VARCHAR(80),
+	seq INTEGER,
+	PRIMARY KEY (
+		user_id,
+		role
+	)
+);
+
+CREATE TABLE IF NOT EXISTS role_column (
+	id VARCHAR(255) PRIMARY KEY,
+	module_id VARCHAR(255),
+	role VARCHAR(80),
+	value VARCHAR(255),
+	hidden CHAR(1),
+	frozen CHAR(1),
+	seq INTEGER,
+	location CHAR(2),
+	width INTEGER,
+	rule VARCHAR(255)
+);
+
+CREATE TABLE IF NOT EXISTS role_filter (
+	id VARCHAR(255) PRIMARY KEY,
+	module_id VARCHAR(255),
+	role VARCHAR(80),
+	value VARCHAR(255),
+	hidden CHAR(2),
+	seq INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS user_search (
+	id VARCHAR(255) PRIMARY KEY,
+	module_id VARCHAR(255),
+	user_id VARCHAR(255),
+	name VARCHAR(255),
+	shared CHAR(1) DEFAULT '0',
+	is_default CHAR(1) DEFAULT '0',
+	filter_values TEXT,
+	created_at VARCHAR(20),
+	updated_at VARCHAR(20)
+);
+
+CREATE TABLE IF NOT EXISTS schema_migration (
+	name VARCHAR(255) PRIMARY KEY,
+	applied_at VARCHAR(20)
+);
+
+CREATE TABLE IF NOT EXISTS override_audit (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	module_id VARCHAR(255),
+	target VARCHAR(10),
+	actor VARCHAR(255),
+	scope TEXT,
+	fields TEXT,
+	dry_run CHAR(1),
+	users INTEGER,
+	rows INTEGER,
+	created_at VARCHAR(20)
+);
+
+CREATE TABLE IF NOT EXISTS role_permission (
+	role VARCHAR(255),
+	code VARCHAR(255),
+	PRIMARY KEY (role, code)
+);
+
+CREATE TABLE IF NOT EXISTS mock_user (
+	id VARCHAR(255) PRIMARY KEY,
+	username VARCHAR(255) UNIQUE,
+	password_hash VARCHAR(255),
+	eno VARCHAR(255),
+	display_name VARCHAR(255),
+	phone VARCHAR(255),
+	company VARCHAR(255),
+	created_at VARCHAR(20)
+);
+
+CREATE TABLE IF NOT EXISTS mock_role (
+	name VARCHAR(255) PRIMARY KEY,
+	description VARCHAR(255),
+	created_at VARCHAR(20)
+);
+
+CREATE TABLE IF NOT EXISTS login_attempt (
+	username VARCHAR(255),
+	ip VARCHAR(64),
+	failures INTEGER DEFAULT 0,
+	locked_until INTEGER DEFAULT 0,
+	updated_at VARCHAR(20),
+	PRIMARY KEY (username, ip)
+);
+
+CREATE TABLE IF NOT EXISTS captcha (
+	id VARCHAR(255) PRIMARY KEY,
+	answer VARCHAR(16),
+	expires_at INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS revoked_token (
+	jti VARCHAR(255) PRIMARY KEY,
+	user_id VARCHAR(255),
+	expires_at INTEGER,
+	revoked_at VARCHAR(20)
+);
+
+CREATE TABLE IF NOT EXISTS module_endpoint (
+	module_id VARCHAR(255) PRIMARY KEY,
+	endpoint VARCHAR(255)
+);
 `
 
 type Column struct {
@@ -90,6 +226,9 @@ type Person struct {
 }
 
 func main() {
+	runMigrations("_db.db")
+	migrateTenantDBs()
+
 	router := http.NewServeMux()
 
 	router.HandleFunc("/", index)
@@ -138,14 +277,28 @@ func main() {
 	router.HandleFunc("/new/platform", newPlatform)
 
 	router.HandleFunc("/login", login)
+	router.HandleFunc("/refresh", refresh)
+	router.HandleFunc("/logout", logout)
+	router.HandleFunc("/tenants", Tenants)
+	router.HandleFunc("/captcha", Captcha)
+	router.HandleFunc("/captcha/answer", CaptchaAnswer)
+	router.HandleFunc("/login/attempts", LoginAttempts)
+	router.HandleFunc("/mock/user", MockUser)
+	router.HandleFunc("/mock/role", MockRole)
 	router.HandleFunc("/permission", permission)
+	router.HandleFunc("/permission/menu", PermissionMenu)
+	router.HandleFunc("/permission/role", PermissionRole)
+	router.HandleFunc("/permission/actions", PermissionActions)
 
 	router.HandleFunc("/data/person", dataPerson)
+	router.HandleFunc("/data/person/generate", GeneratePerson)
+	router.HandleFunc("/data/export", ExportData)
 	router.HandleFunc("/data/column", dataColumn)
 	router.HandleFunc("/data/column/update", dataColumnUpdate)
 	router.HandleFunc("/data/column/width/update", dataColumnWidthUpdate)
 	router.HandleFunc("/data/update/from/csv", updateFromCSV)
 	router.HandleFunc("/data/upload_file", uploadFile)
+	router.HandleFunc("/data/import/csv", ImportCSV)
 
 	router.HandleFunc("/data/test_query_string", testQueryString)
 
@@ -158,14 +311,30 @@ func main() {
 	router.HandleFunc("/custom-table/maintenance/table", GetMaintenanceTable)
 	router.HandleFunc("/custom-table/user/maintenance/table", GetUserMaintenanceTable)
 	router.HandleFunc("/custom-table/user/maintenance/table/width", UpdateUserMaintenanceTableWidth)
+	router.HandleFunc("/custom-table/user/maintenance/table/widths", UpdateUserMaintenanceTableWidths)
 	router.HandleFunc("/custom-table/user/maintenance/reset", ResetUserMaintenanceTable)
 	router.HandleFunc("/custom-table/maintenance/table/overrie-columns", OverrideUserMaintenanceTable)
 	router.HandleFunc("/custom-table/maintenance/filter", GetMaintenanceFilter)
 	router.HandleFunc("/custom-table/user/maintenance/filter", GetUserMaintenanceFilter)
 	router.HandleFunc("/custom-table/user/maintenance/filter/reset", ResetUserMaintenanceFilter)
+	router.HandleFunc("/custom-table/user/maintenance/filter/search", UserMaintenanceSearch)
 	router.HandleFunc("/custom-table/maintenance/filter/overrie-columns", OverrideUserMaintenanceFilter)
-
-	log.Fatal(http.ListenAndServe(":8088", router))
+	router.HandleFunc("/custom-table/maintenance/override/audit", GetOverrideAudit)
+	router.HandleFunc("/custom-table/maintenance/share", ShareUserMaintenanceLayout)
+	router.HandleFunc("/custom-table/maintenance/promote", PromoteUserMaintenanceLayout)
+	router.HandleFunc("/custom-table/maintenance/rule/eval", EvalMaintenanceRule)
+	router.HandleFunc("/custom-table/maintenance/history", GetMaintenanceHistory)
+	router.HandleFunc("/custom-table/maintenance/rollback", RollbackMaintenance)
+	router.HandleFunc("/custom-table/maintenance/export", ExportMaintenance)
+	router.HandleFunc("/custom-table/maintenance/import", ImportMaintenance)
+	router.HandleFunc("/custom-table/maintenance/endpoint", ModuleEndpoint)
+	router.HandleFunc("/custom-table/user/roles", GetUserRoles)
+	router.HandleFunc("/custom-table/role/maintenance/table", GetRoleMaintenanceTable)
+	router.HandleFunc("/custom-table/role/maintenance/reset", ResetRoleMaintenanceTable)
+	router.HandleFunc("/custom-table/role/maintenance/filter", GetRoleMaintenanceFilter)
+	router.HandleFunc("/custom-table/role/maintenance/filter/reset", ResetRoleMaintenanceFilter)
+
+	log.Fatal(http.ListenAndServe(":8088", tenantScope(protect(router))))
 }
 
 type codeRetT struct {
@@ -175,7 +344,7 @@ type codeRetT struct {
 }
 
 func cascadeDS(w http.ResponseWriter, r *http.Request) {
-	plan, _ := ioutil.ReadFile("account.json")
+	plan, _ := ioutil.ReadFile(tenantFile(requestTenant(r).ID, "account.json"))
 	var data interface{}
 	err := json.Unmarshal(plan, &data)
 	if err != nil {
@@ -189,7 +358,7 @@ func cascadeDS(w http.ResponseWriter, r *http.Request) {
 }
 
 func dropDownDS(w http.ResponseWriter, r *http.Request) {
-	plan, _ := ioutil.ReadFile("bank.json")
+	plan, _ := ioutil.ReadFile(tenantFile(requestTenant(r).ID, "bank.json"))
 	var data interface{}
 	err := json.Unmarshal(plan, &data)
 	if err != nil {
@@ -206,7 +375,7 @@ func plateSearch(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
 	
-	plan, _ := ioutil.ReadFile("plate-search.json")
+	plan, _ := ioutil.ReadFile(tenantFile(requestTenant(r).ID, "plate-search.json"))
 	var data interface{}
 	err := json.Unmarshal(plan, &data)
 	if err != nil {
@@ -219,16 +388,6 @@ func plateSearch(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func getEno(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "*")
-	json.NewEncoder(w).Encode(codeRetT{
-		Code:   "0",
-		Result: "0009",
-		Des:    "fullfill the request!",
-	})
-}
-
 func testQueryString(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
@@ -242,12 +401,12 @@ func testQueryString(w http.ResponseWriter, r *http.Request) {
 func updateFromCSV(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		panic(err)
 	}
 
-	csvFile, err := os.Open("MOCK_DATA.csv")
+	csvFile, err := os.Open(tenantFile(requestTenant(r).ID, "MOCK_DATA.csv"))
 	if err != nil {
 		panic(err)
 	}
@@ -278,7 +437,7 @@ func updateFromCSV(w http.ResponseWriter, r *http.Request) {
 func dataColumnWidthUpdate(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		panic(err)
 	}
@@ -298,7 +457,7 @@ func dataColumnWidthUpdate(w http.ResponseWriter, r *http.Request) {
 func dataColumnUpdate(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		panic(err)
 	}
@@ -332,7 +491,7 @@ func dataColumnUpdate(w http.ResponseWriter, r *http.Request) {
 func dataColumn(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -357,7 +516,7 @@ func dataColumn(w http.ResponseWriter, r *http.Request) {
 func dataPerson(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
-	db, err := sqlx.Connect("sqlite3", "_db.db")
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -371,15 +530,37 @@ func dataPerson(w http.ResponseWriter, r *http.Request) {
 	pageSize := 10
 	if len(currentPages) != 0 {
 		currentPage, err = strconv.Atoi(currentPages[0])
+		if err != nil || currentPage < 1 {
+			currentPage = 1
+		}
 	}
 	if len(pageSizes) != 0 {
 		pageSize, err = strconv.Atoi(pageSizes[0])
+		if err != nil || pageSize < 1 {
+			pageSize = 10
+		}
+	}
+
+	q, err := requestPersonQuery(r)
+	if err != nil {
+		db.Close()
+		json.NewEncoder(w).Encode(codeRetT{
+			Code: "1",
+			Des:  err.Error(),
+		})
+		return
 	}
 
 	startOffset := (currentPage - 1) * pageSize
-	db.Select(&people, "select * from person limit $1,$2", startOffset, pageSize)
-	count := make([]int, 2)
-	err = db.Select(&count, "select count(*) from person")
+	err = db.Select(&people, "select * from person"+q.whereSQL()+q.orderSQL()+" limit "+strconv.Itoa(startOffset)+", "+strconv.Itoa(pageSize), q.Args...)
+	if err != nil {
+		panic(err)
+	}
+	var count int
+	err = db.Get(&count, "select count(*) from person"+q.whereSQL(), q.Args...)
+	if err != nil {
+		panic(err)
+	}
 
 	db.Close()
 
@@ -393,7 +574,7 @@ func dataPerson(w http.ResponseWriter, r *http.Request) {
 	pa := PaginationT{
 		PageSize:    pageSize,
 		CurrentPage: currentPage,
-		Total:       count[2],
+		Total:       count,
 		Content:     people,
 	}
 
@@ -411,25 +592,29 @@ func login(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
 
-	username := r.URL.Query()["username"][0]
-	password := r.URL.Query()["password"][0]
-
-	var code, des string
+	cred := requestCredential(r)
 
-	if username == "username" && password == "password" {
-		code = "0"
-		des = "登录成功"
-	} else {
-		code = "1"
-		des = "用户名或密码错误"
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
 	}
 
 	ret := codeRetT{
-		Code:   code,
-		Result: "json web token",
-		Des:    des,
+		Code: "0",
+		Des:  "登录成功",
+	}
+
+	userID, loginErr := authenticate(db, r, cred)
+	if loginErr != nil {
+		ret.Code = loginErr.Code
+		ret.Des = loginErr.Des
+		ret.Result = loginErr.Detail
+	} else {
+		ret.Result = issueToken(db, userID, requestTenant(r).ID, cred.ExpiresIn)
 	}
 
+	db.Close()
+
 	time.Sleep(1 * time.Second)
 	json.NewEncoder(w).Encode(ret)
 }
@@ -438,6 +623,8 @@ type permissionT struct {
 	Name    string        `json:"name"`
 	Path    string        `json:"path"`
 	Icon    string        `json:"icon"`
+	Roles   []string      `json:"roles,omitempty"`
+	Code    string        `json:"code,omitempty"`
 	SubMenu []permissionT `json:"subMenu"`
 }
 
@@ -445,23 +632,28 @@ func permission(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
 
-	plan, _ := ioutil.ReadFile("ds.json")
-	var data []permissionT
+	userID, ok := tokenUserID(w, r)
+	if !ok {
+		return
+	}
 
-	err := json.Unmarshal(plan, &data)
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
+	}
+
+	roles := requestRoles(db, userID)
+	data := pruneMenus(readMenus(), roles, roleCodes(db, roles))
+
+	db.Close()
 
 	ret := codeRetT{
 		Code:   "0",
 		Des:    "response success",
 		Result: data,
 	}
-	if err == nil {
-		time.Sleep(2 * time.Second)
-		json.NewEncoder(w).Encode(ret)
-	} else {
-		fmt.Println(err)
-	}
-
+	time.Sleep(2 * time.Second)
+	json.NewEncoder(w).Encode(ret)
 }
 
 func index(w http.ResponseWriter, r *http.Request) {
@@ -524,15 +716,13 @@ type resRet struct {
 	Data   interface{} `json:"data"`
 }
 
-func market(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "*")
+func marketRows(r *http.Request) []cell {
 	var s []cell
 	for i := 0; i < 10; i++ {
 		s = append(s, cell{
 			GID:                     "#!989XXDDF" + strconv.Itoa(i),
 			BlNo:                    "OPX9089",
-			AgentCompany:            "上海欧恒进出口贸易有限公司",
+			AgentCompany:            requestTenant(r).Company,
 			ForecastUserCompany:     "上海欧恒进出口贸易预报有限公司",
 			GoodsSourceCode:         "0",
 			CreaterRole:             "1",
@@ -541,7 +731,7 @@ func market(w http.ResponseWriter, r *http.Request) {
 			GoodsSource:             "海运柜",
 			PrivateSiteID:           "XXXXX:::::LLLLLL::::::::XXX",
 			Product:                 "鲜蓝莓",
-			DischargeStatus:         "0",
+			DischargeStatus:         strconv.Itoa(i % 3),
 			ForecaseEnterDate:       "2018-6-30 晚上",
 			ContainerNo:             "OOL9093XXF",
 			FrameNo:                 "XFEFG33422",
@@ -560,6 +750,32 @@ func market(w http.ResponseWriter, r *http.Request) {
 			ContainerTypeID:         strconv.Itoa(i % 2),
 		})
 	}
+	return s
+}
+
+func market(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	s := marketRows(r)
+
+	// 配置了规则的按钮按规则计算
+	if rules := readActionRules()["/market/list"]; len(rules) > 0 {
+		roles, codes := requestPermissions(r)
+		for i := range s {
+			for field, flag := range actionFlags(rules, roles, codes, rowMaps(s[i : i+1])[0]) {
+				switch field {
+				case "allowStatus":
+					s[i].AllowStatus = flag
+				case "disallowStatus":
+					s[i].DisallowStatus = flag
+				case "cancelAllowedStatus":
+					s[i].CancelAllowedStatus = flag
+				case "cancelDisallowedStatus":
+					s[i].CancelDisallowedStatus = flag
+				}
+			}
+		}
+	}
 
 	resRet0 := resRet{
 		Result: true,
@@ -577,7 +793,7 @@ func market(w http.ResponseWriter, r *http.Request) {
 }
 
 func freightIndex(w http.ResponseWriter, r *http.Request) {
-	file, err := os.Open("c.txt")
+	file, err := os.Open(tenantFile(requestTenant(r).ID, "c.txt"))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -701,7 +917,7 @@ type pageData struct {
 }
 
 func productIndex(w http.ResponseWriter, r *http.Request) {
-	file, err := os.Open("e.txt")
+	file, err := os.Open(tenantFile(requestTenant(r).ID, "e.txt"))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -750,7 +966,7 @@ func productIndex(w http.ResponseWriter, r *http.Request) {
 }
 
 func countryIndex(w http.ResponseWriter, r *http.Request) {
-	file, err := os.Open("f.txt")
+	file, err := os.Open(tenantFile(requestTenant(r).ID, "f.txt"))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -798,6 +1014,8 @@ func countryIndex(w http.ResponseWriter, r *http.Request) {
 }
 
 func detailIndex(w http.ResponseWriter, r *http.Request) {
+	operators := directoryNames(r)
+
 	type productT struct {
 		BlNo         string `json:"blNo"`
 		ProductID    string `json:"productId"`
@@ -870,7 +1088,7 @@ func detailIndex(w http.ResponseWriter, r *http.Request) {
 			GoodsSourceName:         "散货",
 			ConfirmAreaName:         "A-2",
 			DropCabinetPositionName: "AC",
-			ForecastConfirmUser:     "陈科宇",
+			ForecastConfirmUser:     pickName(operators),
 			ForecastConfirmDate:     "2019-1-1",
 			ContainerSizeID:         xid.New().String(),
 			ContainerSizeName:       "40`",
@@ -961,41 +1179,44 @@ func siteIndex(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func search(w http.ResponseWriter, r *http.Request) {
-	type searchT struct {
-		ForecastEnterDate       string `json:"forecastEnterDate"`
-		ContainerNo             string `json:"containerNo"`
-		FrameNo                 string `json:"frameNo"`
-		PlateNo                 string `json:"plateNo"`
-		GoodsSourceName         string `json:"goodsSourceName"`
-		GoodsSourceID           string `json:"goodsSourceCode"`
-		ContainerSizeName       string `json:"containerSizeName"`
-		DischargeStatus         string `json:"dischargeStatus"`
-		ForecastTimeName        string `json:"forecastTimeName"`
-		IsPublicSite            string `json:"isPublicSite"`
-		PrivateSiteName         string `json:"privateSiteName"`
-		ConfirmAreaName         string `json:"confirmAreaName"`
-		DropCabinetPositionName string `json:"dropCabinetPositionName"`
-		Product                 string `json:"product"`
-		ForecastUserCompany     string `json:"forecastUserCompany"`
-		ForecastUserCompanyRole string `json:"forecastUserCompanyRole"`
-		ForecastConfirmTime     string `json:"forecastConfirmTime"`
-		Operator                string `json:"operator"`
-		AcutalEnterTime         string `json:"acutalEnterTime"`
-		AcutalEnterTimer        string `json:"acutalEnterTimer"`
-		AcutalOutTime           string `json:"acutalOutTime"`
-		AcutalOutTimer          string `json:"acutalOutTimer"`
-		PluginTime              string `json:"pluginTime"`
-		PluginTimer             string `json:"pluginTimer"`
-		PlugoutTime             string `json:"plugoutTime"`
-		PlugoutTimer            string `json:"plugoutTimer"`
-	}
+type searchRowT struct {
+	ForecastEnterDate       string `json:"forecastEnterDate"`
+	ContainerNo             string `json:"containerNo"`
+	FrameNo                 string `json:"frameNo"`
+	PlateNo                 string `json:"plateNo"`
+	GoodsSourceName         string `json:"goodsSourceName"`
+	GoodsSourceID           string `json:"goodsSourceCode"`
+	ContainerSizeName       string `json:"containerSizeName"`
+	DischargeStatus         string `json:"dischargeStatus"`
+	ForecastTimeName        string `json:"forecastTimeName"`
+	IsPublicSite            string `json:"isPublicSite"`
+	PrivateSiteName         string `json:"privateSiteName"`
+	ConfirmAreaName         string `json:"confirmAreaName"`
+	DropCabinetPositionName string `json:"dropCabinetPositionName"`
+	Product                 string `json:"product"`
+	ForecastUserCompany     string `json:"forecastUserCompany"`
+	ForecastUserCompanyRole string `json:"forecastUserCompanyRole"`
+	ForecastConfirmTime     string `json:"forecastConfirmTime"`
+	Operator                string `json:"operator"`
+	AcutalEnterTime         string `json:"acutalEnterTime"`
+	AcutalEnterTimer        string `json:"acutalEnterTimer"`
+	AcutalOutTime           string `json:"acutalOutTime"`
+	AcutalOutTimer          string `json:"acutalOutTimer"`
+	PluginTime              string `json:"pluginTime"`
+	PluginTimer             string `json:"pluginTimer"`
+	PlugoutTime             string `json:"plugoutTime"`
+	PlugoutTimer            string `json:"plugoutTimer"`
+}
+
+func searchRows(r *http.Request) []searchRowT {
+	tenant := requestTenant(r)
+	operators := directoryNames(r)
 
 	rd := rand.New(rand.NewSource(time.Now().UnixNano()))
 
-	cell := make([]searchT, 0)
+	cell := make([]searchRowT, 0)
 	for i := 0; i < 100; i++ {
-		cell = append(cell, searchT{
+		cell = append(cell, searchRowT{
 			ForecastEnterDate:       "2018-08-29",
 			ContainerNo:             xid.New().String()[0:5],
 			FrameNo:                 xid.New().String()[0:5],
@@ -1009,11 +1230,11 @@ func search(w http.ResponseWriter, r *http.Request) {
 			ConfirmAreaName:         "A 区",
 			DropCabinetPositionName: "A345",
 			Product:                 "殷桃 樱桃 车厘子",
-			ForecastUserCompany:     "上海欧恒进出口贸易有限公司",
+			ForecastUserCompany:     tenant.Company,
 			ForecastUserCompanyRole: "货代",
 			ForecastConfirmTime:     "2018-10-10 23:00",
 			ForecastTimeName:        "下午",
-			Operator:                "陈科宇",
+			Operator:                pickName(operators),
 			AcutalEnterTime:         "2018-10-10 23:00",
 			AcutalEnterTimer:        "10天 20小时 3 分",
 			AcutalOutTime:           "2018-10-10 23:00",
@@ -1024,9 +1245,21 @@ func search(w http.ResponseWriter, r *http.Request) {
 			PlugoutTimer:            "10天 20小时 3 分",
 		})
 	}
+	return cell
+}
 
+func search(w http.ResponseWriter, r *http.Request) {
 	r.ParseMultipartForm(1024 * 1024)
 
+	cell, err := applyModuleFilters(r, searchRows(r))
+	if err != nil {
+		json.NewEncoder(w).Encode(resRet{
+			Result: false,
+			Msg:    err.Error(),
+		})
+		return
+	}
+
 	c, err := strconv.Atoi(r.Form["currentPage"][0])
 	if err != nil {
 		c = 1
@@ -1041,6 +1274,9 @@ func search(w http.ResponseWriter, r *http.Request) {
 	if end > len(cell) {
 		end = len(cell)
 	}
+	if start > end {
+		start = end
+	}
 
 	response := resRet{
 		Result: true,
@@ -1081,34 +1317,36 @@ func summary(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func fleetLst(w http.ResponseWriter, r *http.Request) {
-	type fleetT struct {
-		GID               string `json:"gId"`
-		ForecastEnterDate string `json:"forecastEnterDate"`
-		ActualEnterDate   string `json:"actualEnterDate"`
-		ContainerNo       string `json:"containerNo"`
-		FrameNo           string `json:"frameNo"`
-		PlateNo           string `json:"plateNo"`
-		GoodsSourceName   string `json:"goodsSourceName"`
-		GoodsSourceID     string `json:"goodsSourceCode"`
-		ContainerSizeName string `json:"containerSizeName"`
-		CustomID          string `json:"customId"`
-		Product           string `json:"product"`
-
-		ForecastUserCompany     string `json:"forecastUserCompany"`
-		ForecastUserCompanyRole string `json:"forecastUserCompanyRole"`
-
-		Fleet             string `json:"fleet"`
-		FleetSelectedDate string `json:"fleetSelectedDate"`
-		Operator          string `json:"operator"`
-		HasDeparted       bool   `json:"hasDeparted"`
-	}
+type fleetRowT struct {
+	GID               string `json:"gId"`
+	ForecastEnterDate string `json:"forecastEnterDate"`
+	ActualEnterDate   string `json:"actualEnterDate"`
+	ContainerNo       string `json:"containerNo"`
+	FrameNo           string `json:"frameNo"`
+	PlateNo           string `json:"plateNo"`
+	GoodsSourceName   string `json:"goodsSourceName"`
+	GoodsSourceID     string `json:"goodsSourceCode"`
+	ContainerSizeName string `json:"containerSizeName"`
+	CustomID          string `json:"customId"`
+	Product           string `json:"product"`
+
+	ForecastUserCompany     string `json:"forecastUserCompany"`
+	ForecastUserCompanyRole string `json:"forecastUserCompanyRole"`
+
+	Fleet             string `json:"fleet"`
+	FleetSelectedDate string `json:"fleetSelectedDate"`
+	Operator          string `json:"operator"`
+	HasDeparted       bool   `json:"hasDeparted"`
+}
+
+func fleetRows(r *http.Request) []fleetRowT {
+	operators := directoryNames(r)
 
 	rd := rand.New(rand.NewSource(time.Now().UnixNano()))
 
-	cell := make([]fleetT, 0)
+	cell := make([]fleetRowT, 0)
 	for i := 0; i < 100; i++ {
-		cell = append(cell, fleetT{
+		cell = append(cell, fleetRowT{
 			GID:                     xid.New().String(),
 			ForecastEnterDate:       "2018-08-29",
 			ActualEnterDate:         "2018-08-29",
@@ -1125,9 +1363,14 @@ func fleetLst(w http.ResponseWriter, r *http.Request) {
 			Product:                 "殷桃 樱桃 车厘子",
 			ForecastUserCompany:     "上海欧恒进出口贸易有限公司",
 			ForecastUserCompanyRole: "货代",
-			Operator:                "陈科宇",
+			Operator:                pickName(operators),
 		})
 	}
+	return cell
+}
+
+func fleetLst(w http.ResponseWriter, r *http.Request) {
+	cell := fleetRows(r)
 
 	r.ParseMultipartForm(1024 * 1024)
 
@@ -1175,34 +1418,37 @@ func setFleet(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func outApplicationList(w http.ResponseWriter, r *http.Request) {
-	type outAppListT struct {
-		GID                     string `json:"gId"`
-		ContainerNo             string `json:"containerNo"`
-		FrameNo                 string `json:"frameNo"`
-		PlateNo                 string `json:"plateNo"`
-		GoodsSourceName         string `json:"goodsSourceName"`
-		GoodsSourceID           string `json:"goodsSourceCode"`
-		ContainerSizeName       string `json:"containerSizeName"`
-		ForecastUserCompany     string `json:"forecastUserCompany"`
-		ForecastUserCompanyRole string `json:"forecastCompanyRoleName"`
-		IsPublicSite            string `json:"isPublicSite"`
-		PrivateSiteName         string `json:"privateSiteName"`
-		ConfirmAreaName         string `json:"confirmAreaName"`
-		DropCabinetPositionName string `json:"dropCabinetPositionName"`
-		CancelStatus            bool   `json:"cancelStatus"`
-		ApplyOutTime            string `json:"applyOutTime"`
-		ApplyOutOperateTime     string `json:"applyOutOperateTime"`
-		ApplyOutUser            string `json:"applyOutUser"`
-		LastOutTime             string `json:"lastOutTime"`
-	}
+type outApplicationRowT struct {
+	GID                     string `json:"gId"`
+	ContainerNo             string `json:"containerNo"`
+	FrameNo                 string `json:"frameNo"`
+	PlateNo                 string `json:"plateNo"`
+	GoodsSourceName         string `json:"goodsSourceName"`
+	GoodsSourceID           string `json:"goodsSourceCode"`
+	ContainerSizeName       string `json:"containerSizeName"`
+	ForecastUserCompany     string `json:"forecastUserCompany"`
+	ForecastUserCompanyRole string `json:"forecastCompanyRoleName"`
+	IsPublicSite            string `json:"isPublicSite"`
+	PrivateSiteName         string `json:"privateSiteName"`
+	ConfirmAreaName         string `json:"confirmAreaName"`
+	DropCabinetPositionName string `json:"dropCabinetPositionName"`
+	CancelStatus            bool   `json:"cancelStatus"`
+	ApplyOutTime            string `json:"applyOutTime"`
+	ApplyOutOperateTime     string `json:"applyOutOperateTime"`
+	ApplyOutUser            string `json:"applyOutUser"`
+	LastOutTime             string `json:"lastOutTime"`
+}
+
+func outApplicationRows(r *http.Request) []outApplicationRowT {
+	tenant := requestTenant(r)
+	operators := directoryNames(r)
 
 	rd := rand.New(rand.NewSource(time.Now().UnixNano()))
 
-	cell := make([]outAppListT, 0)
+	cell := make([]outApplicationRowT, 0)
 
 	for i := 0; i < 100; i++ {
-		cell = append(cell, outAppListT{
+		cell = append(cell, outApplicationRowT{
 			GID:                     xid.New().String(),
 			ContainerNo:             xid.New().String()[0:5],
 			FrameNo:                 xid.New().String()[0:5],
@@ -1210,22 +1456,35 @@ func outApplicationList(w http.ResponseWriter, r *http.Request) {
 			GoodsSourceName:         "散货",
 			GoodsSourceID:           strconv.Itoa(rd.Int() % 2),
 			ContainerSizeName:       "#13",
-			ForecastUserCompany:     "上海欧恒进出口贸易有限公司",
+			ForecastUserCompany:     tenant.Company,
 			ForecastUserCompanyRole: "货代",
 			IsPublicSite:            strconv.Itoa(rd.Int() % 2),
 			PrivateSiteName:         "A3",
 			ConfirmAreaName:         "A 区",
 			DropCabinetPositionName: "A345",
-			CancelStatus:            rd.Int()%2 == 0,
+			CancelStatus:            false,
 			ApplyOutOperateTime:     "2018-09-11",
 			ApplyOutTime:            "2018-09-11",
-			ApplyOutUser:            "陈科宇",
+			ApplyOutUser:            pickName(operators),
 			LastOutTime:             "2018-09-11",
 		})
 	}
+	return cell
+}
 
+func outApplicationList(w http.ResponseWriter, r *http.Request) {
 	r.ParseMultipartForm(1024 * 1024)
 
+	cell, err := applyModuleFilters(r, outApplicationRows(r))
+	if err != nil {
+		json.NewEncoder(w).Encode(resRet{
+			Result: false,
+			Msg:    err.Error(),
+		})
+		return
+	}
+	applyActionRules(r, "/market/out-application/list", cell)
+
 	c, err := strconv.Atoi(r.Form["currentPage"][0])
 	if err != nil {
 		c = 1
@@ -1240,6 +1499,9 @@ func outApplicationList(w http.ResponseWriter, r *http.Request) {
 	if end > len(cell) {
 		end = len(cell)
 	}
+	if start > end {
+		start = end
+	}
 
 	for k, v := range r.Form {
 		if k != "token" {
@@ -1277,32 +1539,34 @@ func outApplicationCancel(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func pluginApplicationList(w http.ResponseWriter, r *http.Request) {
-	type appT struct {
-		GID                     string `json:"gId"`
-		EID                     string `json:"eId"`
-		ConfirmAreaName         string `json:"confirmAreaName"`
-		DropCabinetPositionName string `json:"dropCabinetPositionName"`
-		ContainerNo             string `json:"containerNo"`
-		FrameNo                 string `json:"frameNo"`
-		PlateNo                 string `json:"plateNo"`
-		GoodsSourceID           string `json:"goodsSourceCode"`
-		PluginDuration          string `json:"pluginDuration"`
-		ApplyPlugInDate         string `json:"applyPlugInDate"`
-		ApplyPlugOutDate        string `json:"applyPlugOutDate"`
-		ApplyDate               string `json:"applyDate"`
-		Operator                string `json:"operator"`
-		CancelStatus            bool   `json:"cancelStatus"`
-		PluginStatus            bool   `json:"pluginStatus"`
-		PlugoutStatus           bool   `json:"plugoutStatus"`
-	}
+type pluginApplicationRowT struct {
+	GID                     string `json:"gId"`
+	EID                     string `json:"eId"`
+	ConfirmAreaName         string `json:"confirmAreaName"`
+	DropCabinetPositionName string `json:"dropCabinetPositionName"`
+	ContainerNo             string `json:"containerNo"`
+	FrameNo                 string `json:"frameNo"`
+	PlateNo                 string `json:"plateNo"`
+	GoodsSourceID           string `json:"goodsSourceCode"`
+	PluginDuration          string `json:"pluginDuration"`
+	ApplyPlugInDate         string `json:"applyPlugInDate"`
+	ApplyPlugOutDate        string `json:"applyPlugOutDate"`
+	ApplyDate               string `json:"applyDate"`
+	Operator                string `json:"operator"`
+	CancelStatus            bool   `json:"cancelStatus"`
+	PluginStatus            bool   `json:"pluginStatus"`
+	PlugoutStatus           bool   `json:"plugoutStatus"`
+}
+
+func pluginApplicationRows(r *http.Request) []pluginApplicationRowT {
+	operators := directoryNames(r)
 
 	rd := rand.New(rand.NewSource(time.Now().UnixNano()))
 
-	cell := make([]appT, 0)
+	cell := make([]pluginApplicationRowT, 0)
 
 	for i := 0; i < 100; i++ {
-		cell = append(cell, appT{
+		cell = append(cell, pluginApplicationRowT{
 			GID:                     xid.New().String(),
 			EID:                     xid.New().String(),
 			ConfirmAreaName:         "A 区",
@@ -1315,12 +1579,17 @@ func pluginApplicationList(w http.ResponseWriter, r *http.Request) {
 			ApplyPlugInDate:         "2018-08-11",
 			ApplyPlugOutDate:        "2018-08-11",
 			ApplyDate:               "2018-08-11",
-			Operator:                "陈科宇",
+			Operator:                pickName(operators),
 			CancelStatus:            rd.Int()%2 == 0,
 			PluginStatus:            rd.Int()%2 == 0,
 			PlugoutStatus:           rd.Int()%2 == 0,
 		})
 	}
+	return cell
+}
+
+func pluginApplicationList(w http.ResponseWriter, r *http.Request) {
+	cell := pluginApplicationRows(r)
 
 	r.ParseMultipartForm(1024 * 1024)
 
@@ -1367,29 +1636,31 @@ func pluginApplicationList(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func marketSettlementList(w http.ResponseWriter, r *http.Request) {
-	type settlementT struct {
-		GID                       string `json:"gId"`
-		ActualEnterDate           string `json:"actualEnterDate"`
-		ActualOutDate             string `json:"actualOutDate"`
-		ContainerNo               string `json:"containerNo"`
-		FrameNo                   string `json:"frameNo"`
-		PlateNo                   string `json:"plateNo"`
-		GoodsSourceName           string `json:"goodsSourceName"`
-		ContainerSizeName         string `json:"containerSizeName"`
-		Product                   string `json:"product"`
-		ForecastUserCompany       string `json:"forecastUserCompany"`
-		ForecastUserCompanyRole   string `json:"forecastUserCompanyRole"`
-		Fee                       string `json:"fee"`
-		SettlementConfirmCompany  string `json:"settlementConfirmCompany"`
-		SettlementConfirmDate     string `json:"settlementConfirmDate"`
-		SettlementConfirmOperator string `json:"settlementConfirmOperator"`
-	}
+type settlementRowT struct {
+	GID                       string `json:"gId"`
+	ActualEnterDate           string `json:"actualEnterDate"`
+	ActualOutDate             string `json:"actualOutDate"`
+	ContainerNo               string `json:"containerNo"`
+	FrameNo                   string `json:"frameNo"`
+	PlateNo                   string `json:"plateNo"`
+	GoodsSourceName           string `json:"goodsSourceName"`
+	ContainerSizeName         string `json:"containerSizeName"`
+	Product                   string `json:"product"`
+	ForecastUserCompany       string `json:"forecastUserCompany"`
+	ForecastUserCompanyRole   string `json:"forecastUserCompanyRole"`
+	Fee                       string `json:"fee"`
+	SettlementConfirmCompany  string `json:"settlementConfirmCompany"`
+	SettlementConfirmDate     string `json:"settlementConfirmDate"`
+	SettlementConfirmOperator string `json:"settlementConfirmOperator"`
+}
+
+func settlementRows(r *http.Request) []settlementRowT {
+	operators := directoryNames(r)
 
-	cell := make([]settlementT, 0)
+	cell := make([]settlementRowT, 0)
 
 	for i := 0; i < 100; i++ {
-		cell = append(cell, settlementT{
+		cell = append(cell, settlementRowT{
 			GID:                     xid.New().String(),
 			ContainerNo:             xid.New().String()[0:5],
 			FrameNo:                 xid.New().String()[0:5],
@@ -1404,9 +1675,14 @@ func marketSettlementList(w http.ResponseWriter, r *http.Request) {
 			Fee:                     "1200",
 			SettlementConfirmCompany:  "上海欧恒进出口贸易有限公司",
 			SettlementConfirmDate:     "2018-10-10",
-			SettlementConfirmOperator: "陈科宇",
+			SettlementConfirmOperator: pickName(operators),
 		})
 	}
+	return cell
+}
+
+func marketSettlementList(w http.ResponseWriter, r *http.Request) {
+	cell := settlementRows(r)
 
 	r.ParseMultipartForm(1024 * 1024)
 
@@ -1446,6 +1722,8 @@ func marketSettlementList(w http.ResponseWriter, r *http.Request) {
 }
 
 func marketSettlementDetail(w http.ResponseWriter, r *http.Request) {
+	operators := directoryNames(r)
+
 	type productT struct {
 		Product      string `json:"product"`
 		GrossWeight  string `json:"grossWeight"`
@@ -1599,7 +1877,7 @@ func marketSettlementDetail(w http.ResponseWriter, r *http.Request) {
 		Fee: "12345",
 		SettlementConfirmCompany:  "上海欧恒进出口贸易有限公司",
 		SettlementConfirmDate:     "2018-10-10",
-		SettlementConfirmOperator: "陈科宇",
+		SettlementConfirmOperator: pickName(operators),
 	}
 
 	response := resRet{
@@ -1611,25 +1889,27 @@ func marketSettlementDetail(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func marketLauList(w http.ResponseWriter, r *http.Request) {
-	type settlementT struct {
-		GID               string `json:"gId"`
-		ContainerNo       string `json:"containerNo"`
-		FrameNo           string `json:"frameNo"`
-		PlateNo           string `json:"plateNo"`
-		GoodsSourceName   string `json:"goodsSourceName"`
-		ContainerSizeName string `json:"containerSizeName"`
+type lauRowT struct {
+	GID               string `json:"gId"`
+	ContainerNo       string `json:"containerNo"`
+	FrameNo           string `json:"frameNo"`
+	PlateNo           string `json:"plateNo"`
+	GoodsSourceName   string `json:"goodsSourceName"`
+	ContainerSizeName string `json:"containerSizeName"`
+
+	CustomerCompany       string `json:"customerCompany"`
+	FleetSelectedTime     string `json:"fleetSelectedTime"`
+	FleetSelectedOperator string `json:"fleetSelectedOperator"`
+	AcutalEnterTime       string `json:"acutalEnterTime"`
+}
 
-		CustomerCompany       string `json:"customerCompany"`
-		FleetSelectedTime     string `json:"fleetSelectedTime"`
-		FleetSelectedOperator string `json:"fleetSelectedOperator"`
-		AcutalEnterTime       string `json:"acutalEnterTime"`
-	}
+func lauRows(r *http.Request) []lauRowT {
+	operators := directoryNames(r)
 
-	cell := make([]settlementT, 0)
+	cell := make([]lauRowT, 0)
 
 	for i := 0; i < 100; i++ {
-		cell = append(cell, settlementT{
+		cell = append(cell, lauRowT{
 			GID:                   xid.New().String(),
 			ContainerNo:           xid.New().String()[0:5],
 			FrameNo:               xid.New().String()[0:5],
@@ -1639,9 +1919,14 @@ func marketLauList(w http.ResponseWriter, r *http.Request) {
 			CustomerCompany:       "上海欧恒进出口贸易有限公司",
 			FleetSelectedTime:     "2018-09-19",
 			AcutalEnterTime:       "2018-10-10",
-			FleetSelectedOperator: "陈科宇",
+			FleetSelectedOperator: pickName(operators),
 		})
 	}
+	return cell
+}
+
+func marketLauList(w http.ResponseWriter, r *http.Request) {
+	cell := lauRows(r)
 
 	r.ParseMultipartForm(1024 * 1024)
 
@@ -1712,32 +1997,32 @@ func newPlatform(w http.ResponseWriter, r *http.Request) {
 		Des    string `json:"des"`
 	}
 
-	type upT struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+	cred := requestCredential(r)
+
+	db, err := sqlx.Connect("sqlite3", tenantDB(r))
+	if err != nil {
+		panic(err)
 	}
 
 	codeRet := codeRetT{
-		Code:   "0",
-		Des:    "登录成功",
-		Result: "验证不通过",
+		Code: "0",
+		Des:  "登录成功",
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	var data upT
-	err := decoder.Decode(&data)
-	if err != nil {
-		panic(err)
+	userID, loginErr := authenticate(db, r, cred)
+	if loginErr != nil {
+		codeRet.Code = loginErr.Code
+		codeRet.Des = loginErr.Des
+		codeRet.Result = "验证不通过"
+	} else {
+		codeRet.Result = issueToken(db, userID, requestTenant(r).ID, cred.ExpiresIn)
 	}
 
-	log.Println(data.Username)
-	log.Println(data.Password)
+	db.Close()
 
 	time.Sleep(1 * time.Second)
 
 	json.NewEncoder(w).Encode(codeRet)
-	// w.WriteHeader(http.StatusInternalServerError)
-
 }
 
 func enterStatistics(w http.ResponseWriter, r *http.Request) {